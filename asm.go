@@ -0,0 +1,94 @@
+package kang
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// sfiles splits the SFiles of pkg into those assembled with the Go
+// assembler and those compiled with the C compiler. The SFiles of a
+// package which uses cgo are compiled with the C compiler, except for
+// runtime/cgo, which bridges the two and has both.
+func (pkg *Package) sfiles() (goasm, gcc []string) {
+	if len(pkg.CgoFiles) == 0 {
+		return pkg.SFiles, nil
+	}
+	if !(pkg.Standard && pkg.ImportPath == "runtime/cgo") {
+		return nil, pkg.SFiles
+	}
+	for _, f := range pkg.SFiles {
+		if strings.HasPrefix(f, "gcc_") {
+			gcc = append(gcc, f)
+		} else {
+			goasm = append(goasm, f)
+		}
+	}
+	return goasm, gcc
+}
+
+// asmArgs returns the arguments passed to every invocation of the
+// assembler for pkg.
+func (pkg *Package) asmArgs() []string {
+	var args []string
+	if toolFlag("asm", "p") {
+		// without -p the assembler marks its objects unlinkable.
+		args = append(args, "-p", pkg.compilePath())
+	}
+	if pkg.Standard && toolFlag("asm", "std") {
+		args = append(args, "-std")
+	}
+	return append(args,
+		"-I", pkg.objdir(),
+		"-I", filepath.Join(runtime.GOROOT(), "pkg", "include"),
+		"-D", "GOOS_"+pkg.GOOS,
+		"-D", "GOARCH_"+pkg.GOARCH,
+	)
+}
+
+// symabis writes the ABIs of the symbols defined in sfiles, which the
+// compiler needs to call them, and returns the path of the file written.
+// It returns a blank path if the toolchain predates symbol ABIs.
+func (pkg *Package) symabis(sfiles []string) (string, error) {
+	if !toolFlag("asm", "gensymabis") {
+		return "", nil
+	}
+	objdir := pkg.objdir()
+	if err := mkdir(objdir); err != nil {
+		return "", err
+	}
+	// the sfiles may include go_asm.h, which the compiler has yet
+	// to write; an empty header is enough to find the symbols.
+	if err := ioutil.WriteFile(filepath.Join(objdir, "go_asm.h"), nil, 0644); err != nil {
+		return "", err
+	}
+	symabis := filepath.Join(objdir, "symabis")
+	args := stringList(pkg.asmArgs(), []string{"-gensymabis", "-o", symabis}, sfiles)
+	cmd := exec.Command(tool("asm"), args...)
+	cmd.Env = pkg.environ()
+	cmd.Dir = pkg.Dir
+	return symabis, run(cmd)
+}
+
+// asm assembles sfiles with the Go assembler and returns the object
+// files produced. The go_asm.h header written by the compiler must
+// already be present in pkg's objdir.
+func (pkg *Package) asm(sfiles []string) ([]string, error) {
+	objdir := pkg.objdir()
+	args := pkg.asmArgs()
+
+	var ofiles []string
+	for _, sfile := range sfiles {
+		ofile := filepath.Join(objdir, strings.TrimSuffix(sfile, ".s")+".o")
+		cmd := exec.Command(tool("asm"), stringList(args, []string{"-o", ofile, sfile})...)
+		cmd.Env = pkg.environ()
+		cmd.Dir = pkg.Dir
+		if err := run(cmd); err != nil {
+			return nil, err
+		}
+		ofiles = append(ofiles, ofile)
+	}
+	return ofiles, nil
+}
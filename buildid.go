@@ -0,0 +1,148 @@
+package kang
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// buildID returns the build ID of pkg. The build ID is a hash of
+// everything that affects the output of the compiler and linker for
+// this package: the contents of its source files, the archives of its
+// dependencies, the version of the toolchain, and the properties of the
+// Context used to build it.
+func (pkg *Package) buildID() (string, error) {
+	h := sha1.New()
+	fmt.Fprintf(h, "toolchain %s\n", ToolchainVersion())
+	fmt.Fprintf(h, "target %s %s\n", pkg.GOOS, pkg.GOARCH)
+	fmt.Fprintf(h, "importpath %s\n", pkg.ImportPath)
+	fmt.Fprintf(h, "race %v\n", pkg.race)
+	fmt.Fprintf(h, "gcflags %q\n", pkg.gcflags)
+	fmt.Fprintf(h, "tags %q\n", pkg.buildtags)
+	if pkg.Main {
+		fmt.Fprintf(h, "ldflags %q\n", pkg.ldflags)
+	}
+	if len(pkg.CgoFiles) > 0 {
+		fmt.Fprintf(h, "cgo %q %q %q %q\n", cc(), os.Getenv("CGO_CPPFLAGS"), os.Getenv("CGO_CFLAGS"), os.Getenv("CGO_LDFLAGS"))
+	}
+
+	for _, file := range pkg.files() {
+		sum, err := pkg.hashFile(filepath.Join(pkg.Dir, file))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %s %s\n", file, sum)
+	}
+
+	for _, p := range pkg.Imports {
+		if p.ImportPath == "C" || p.ImportPath == "unsafe" {
+			continue // synthetic packages have no archive
+		}
+		sum, err := pkg.hashFile(p.pkgpath())
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "import %s %s\n", p.ImportPath, sum)
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// buildIDFile returns the location of the file recording the build ID
+// of the installed version of pkg. A command may be linked to more than
+// one place, see Output, so the build ID of each binary is recorded
+// separately.
+func (pkg *Package) buildIDFile() string {
+	file := strings.TrimSuffix(pkg.pkgpath(), ".a")
+	if pkg.Main {
+		bin, err := filepath.Abs(pkg.Binfile())
+		if err != nil {
+			bin = pkg.Binfile()
+		}
+		file += fmt.Sprintf("-%x", sha1.Sum([]byte(bin)))
+	}
+	return file + ".buildid"
+}
+
+// writeBuildID records the build ID of pkg next to its archive.
+func (pkg *Package) writeBuildID() error {
+	id, err := pkg.buildID()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(pkg.buildIDFile(), []byte(id), 0644)
+}
+
+// hashFile returns the hex encoded sha1 of the contents of path.
+// Results are cached for the lifetime of the Context; forgetHash must
+// be called before a file is rewritten.
+func (c *Context) hashFile(path string) (string, error) {
+	c.mu.Lock()
+	sum, ok := c.hashes[path]
+	c.mu.Unlock()
+	if ok {
+		return sum, nil
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hashes == nil {
+		c.hashes = make(map[string]string)
+	}
+	c.hashes[path] = sum
+	return sum, nil
+}
+
+// forgetHash removes any cached hash of path.
+func (c *Context) forgetHash(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hashes, path)
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+var toolchain struct {
+	sync.Once
+	version string
+}
+
+// ToolchainVersion returns a string identifying the toolchain in use.
+// Release toolchains record their version in $GOROOT/VERSION; for
+// development toolchains the hash of the compiler binary is used.
+func ToolchainVersion() string {
+	toolchain.Do(func() {
+		if v, err := ioutil.ReadFile(filepath.Join(runtime.GOROOT(), "VERSION")); err == nil {
+			toolchain.version = strings.TrimSpace(strings.SplitN(string(v), "\n", 2)[0])
+			return
+		}
+		sum, err := hashFile(tool("compile"))
+		if err != nil {
+			sum = "unknown"
+		}
+		toolchain.version = "devel " + sum
+	})
+	return toolchain.version
+}
@@ -0,0 +1,241 @@
+package kang
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// cgo runs cgo over the CgoFiles of pkg and compiles the resulting C
+// sources, along with pkg's CFiles and those of its SFiles which are not
+// Go assembly, with the C compiler. It returns the generated Go files,
+// which must be compiled with the package, and the object files which
+// must be packed into the package's archive.
+func (pkg *Package) cgo() ([]string, []string, error) {
+	objdir := pkg.cgoObjdir()
+	if err := mkdir(objdir); err != nil {
+		return nil, nil, err
+	}
+
+	cppflags, cflags, ldflags, err := pkg.cflags()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// step 1. generate Go and C sources from the CgoFiles.
+	args := []string{"-objdir", objdir, "-importpath", pkg.ImportPath}
+	if pkg.Standard && pkg.ImportPath == "runtime/cgo" {
+		// runtime/cgo may import neither itself nor syscall.
+		args = append(args, "-import_runtime_cgo=false")
+		if toolFlag("cgo", "import_syscall") {
+			args = append(args, "-import_syscall=false")
+		}
+	}
+	args = append(args, "--")
+	args = append(args, cppflags...)
+	args = append(args, cflags...)
+	args = append(args, pkg.CgoFiles...)
+	cmd := exec.Command(tool("cgo"), args...)
+	cmd.Dir = pkg.Dir
+	// cgo records CGO_LDFLAGS in the generated Go source so they
+	// are passed to the external linker.
+	cmd.Env = append(pkg.environ(), "CGO_LDFLAGS="+strings.Join(ldflags, " "))
+	if err := run(cmd); err != nil {
+		return nil, nil, err
+	}
+
+	gofiles := []string{filepath.Join(objdir, "_cgo_gotypes.go")}
+	cfiles := []string{filepath.Join(objdir, "_cgo_export.c")}
+	for _, f := range pkg.CgoFiles {
+		f = strings.TrimSuffix(f, ".go")
+		gofiles = append(gofiles, filepath.Join(objdir, f+".cgo1.go"))
+		cfiles = append(cfiles, filepath.Join(objdir, f+".cgo2.c"))
+	}
+	_, sfiles := pkg.sfiles()
+	for _, f := range stringList(pkg.CFiles, sfiles) {
+		cfiles = append(cfiles, filepath.Join(pkg.Dir, f))
+	}
+
+	// step 2. compile the C sources.
+	var ofiles []string
+	for i, f := range cfiles {
+		ofile := filepath.Join(objdir, fmt.Sprintf("_x%03d.o", i))
+		if err := pkg.gcc(stringList(cppflags, cflags, []string{"-c", "-o", ofile, f})...); err != nil {
+			return nil, nil, err
+		}
+		ofiles = append(ofiles, ofile)
+	}
+
+	// step 3. link the objects into a dynamic executable so cgo can
+	// discover which dynamic symbols the package imports.
+	mainobj := filepath.Join(objdir, "_cgo_main.o")
+	if err := pkg.gcc(stringList(cppflags, cflags, []string{"-c", "-o", mainobj, filepath.Join(objdir, "_cgo_main.c")})...); err != nil {
+		return nil, nil, err
+	}
+	dynobj := filepath.Join(objdir, "_cgo_.o")
+	if err := pkg.gcc(stringList([]string{"-o", dynobj, mainobj}, ofiles, ldflags)...); err != nil {
+		return nil, nil, err
+	}
+
+	importgo := filepath.Join(objdir, "_cgo_import.go")
+	args = []string{"-objdir", objdir, "-dynpackage", pkg.Name, "-dynimport", dynobj, "-dynout", importgo}
+	if pkg.Standard && pkg.ImportPath == "runtime/cgo" {
+		args = append(args, "-dynlinker") // record path to dynamic linker
+	}
+	cmd = exec.Command(tool("cgo"), args...)
+	cmd.Dir = pkg.Dir
+	cmd.Env = pkg.environ()
+	if err := run(cmd); err != nil {
+		return nil, nil, err
+	}
+	gofiles = append(gofiles, importgo)
+
+	return gofiles, ofiles, nil
+}
+
+// cgoObjdir returns the directory in which cgo's intermediate files
+// for pkg are written.
+func (pkg *Package) cgoObjdir() string {
+	return filepath.Join(pkg.objdir(), "_cgo")
+}
+
+// cflags returns the flags passed to the C compiler and linker for pkg,
+// combining the #cgo directives in its source, the output of pkg-config
+// and the CGO_ environment variables. Flags from the directives and
+// pkg-config are checked against those known to be safe.
+func (pkg *Package) cflags() (cppflags, cflags, ldflags []string, err error) {
+	directive := func(name string) string {
+		return "#cgo " + name + " of " + pkg.ImportPath
+	}
+	if err := checkCompilerFlags(directive("CPPFLAGS"), pkg.CgoCPPFLAGS); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkCompilerFlags(directive("CFLAGS"), pkg.CgoCFLAGS); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkLinkerFlags(directive("LDFLAGS"), pkg.CgoLDFLAGS); err != nil {
+		return nil, nil, nil, err
+	}
+	cppflags = stringList(envList("CGO_CPPFLAGS", ""), pkg.CgoCPPFLAGS)
+	cflags = stringList(envList("CGO_CFLAGS", "-g -O2"), pkg.CgoCFLAGS)
+	ldflags = stringList(envList("CGO_LDFLAGS", "-g -O2"), pkg.CgoLDFLAGS)
+	if len(pkg.CgoPkgConfig) > 0 {
+		if err := checkPkgConfig(pkg.CgoPkgConfig); err != nil {
+			return nil, nil, nil, fmt.Errorf("%s: %v", directive("pkg-config"), err)
+		}
+		out, err := pkgconfig("--cflags", pkg.CgoPkgConfig...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := checkCompilerFlags("pkg-config --cflags output for "+pkg.ImportPath, out); err != nil {
+			return nil, nil, nil, err
+		}
+		cppflags = append(cppflags, out...)
+		out, err = pkgconfig("--libs", pkg.CgoPkgConfig...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := checkLinkerFlags("pkg-config --libs output for "+pkg.ImportPath, out); err != nil {
+			return nil, nil, nil, err
+		}
+		ldflags = append(ldflags, out...)
+	}
+	cppflags = append(cppflags, "-I", pkg.cgoObjdir(), "-I", pkg.Dir)
+	return cppflags, cflags, ldflags, nil
+}
+
+// gcc runs the C compiler with args in the package directory.
+func (pkg *Package) gcc(args ...string) error {
+	args = stringList(gccArchArgs(pkg.GOARCH), []string{"-pthread", "-fmessage-length=0"}, args)
+	if pkg.GOOS != "windows" {
+		// the Go linker, when it links internally, can only
+		// relocate references to dynamic data through the GOT.
+		args = append([]string{"-fPIC"}, args...)
+	}
+	cmd := exec.Command(cc(), args...)
+	cmd.Dir = pkg.Dir
+	return run(cmd)
+}
+
+// pack appends ofiles to the archive of pkg.
+func (pkg *Package) pack(ofiles ...string) error {
+	args := stringList([]string{"r", pkg.pkgpath()}, ofiles)
+	cmd := exec.Command(tool("pack"), args...)
+	if _, err := os.Stat(cmd.Path); err != nil {
+		// recent toolchains do not ship pack, the go
+		// command builds it on demand.
+		cmd = exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), stringList([]string{"tool", "pack"}, args)...)
+	}
+	cmd.Dir = pkg.Dir
+	return run(cmd)
+}
+
+// externalLink reports whether pkg must be linked with the external
+// linker; that is, if any package it depends on, other than those in
+// the standard library, uses cgo.
+func (pkg *Package) externalLink() bool {
+	seen := make(map[*Package]bool)
+	var walk func(p *Package) bool
+	walk = func(p *Package) bool {
+		if seen[p] {
+			return false
+		}
+		seen[p] = true
+		if !p.Standard && len(p.CgoFiles) > 0 {
+			return true
+		}
+		for _, i := range p.Imports {
+			if walk(i) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(pkg)
+}
+
+// pkgconfig runs pkg-config with flag over pkgs and returns the result
+// split into fields.
+func pkgconfig(flag string, pkgs ...string) ([]string, error) {
+	cmd := exec.Command("pkg-config", stringList([]string{flag}, pkgs)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("pkg-config %s %s: %v\n%s", flag, strings.Join(pkgs, " "), err, out)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// cc returns the C compiler to use, $CC or gcc.
+func cc() string {
+	if cc := os.Getenv("CC"); cc != "" {
+		return cc
+	}
+	return "gcc"
+}
+
+// gccArchArgs returns the arguments needed to tell the C compiler
+// which architecture to generate code for.
+func gccArchArgs(goarch string) []string {
+	switch goarch {
+	case "386":
+		return []string{"-m32"}
+	case "amd64", "amd64p32":
+		return []string{"-m64"}
+	case "arm":
+		return []string{"-marm"} // not thumb
+	}
+	return nil
+}
+
+// envList returns the value of the environment variable key split
+// into fields, or def if key is not set.
+func envList(key, def string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		v = def
+	}
+	return strings.Fields(v)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// An Action is a node in the build graph. An Action is run once
+// all the Actions it depends on have completed successfully.
+type Action struct {
+	Name string    // name of the action, used when reporting errors
+	Deps []*Action // actions which must complete before this action
+	Run  func() error
+
+	done bool // Run completed successfully
+}
+
+var (
+	buildJobs int  // -j
+	keepGoing bool // -k
+	keepWork  bool // -work
+
+	buildForce   bool   // -f
+	buildRace    bool   // -race
+	buildGcflags string // -gcflags
+	buildLdflags string // -ldflags
+	buildTags    string // -tags
+)
+
+// addBuildFlags registers the flags common to commands which build packages.
+func addBuildFlags(fs *flag.FlagSet) {
+	fs.IntVar(&buildJobs, "j", runtime.NumCPU(), "number of packages to build in parallel")
+	fs.BoolVar(&keepGoing, "k", false, "continue building as much as possible after an error")
+	fs.BoolVar(&keepWork, "work", false, "print the name of the temporary work directory and do not remove it on exit")
+	fs.BoolVar(&buildForce, "f", false, "rebuild every package, even if it is up to date")
+	addContextFlags(fs)
+}
+
+// addContextFlags registers the flags which select how packages are
+// built, and so which source files and archives are used.
+func addContextFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&buildRace, "race", false, "enable the race detector")
+	fs.StringVar(&buildGcflags, "gcflags", "", "space separated `flags` to pass to the compiler")
+	fs.StringVar(&buildLdflags, "ldflags", "", "space separated `flags` to pass to the linker")
+	fs.StringVar(&buildTags, "tags", "", "space or comma separated build `tags`")
+}
+
+// Execute runs root and the actions it depends on using up to jobs
+// concurrent workers. Actions are started once all their dependencies
+// have completed successfully. If an action fails, its error is reported
+// and no further actions are started, unless keepGoing is true, in which
+// case every action whose dependencies succeeded is still run.
+func Execute(root *Action, jobs int, keepGoing bool) error {
+	var all []*Action
+	pending := make(map[*Action]int)
+	dependents := make(map[*Action][]*Action)
+	seen := make(map[*Action]bool)
+
+	var walk func(a *Action)
+	walk = func(a *Action) {
+		if seen[a] {
+			return
+		}
+		seen[a] = true
+		for _, dep := range a.Deps {
+			walk(dep)
+			dependents[dep] = append(dependents[dep], a)
+		}
+		pending[a] = len(a.Deps)
+		all = append(all, a)
+	}
+	walk(root)
+
+	var queue []*Action
+	for _, a := range all {
+		if pending[a] == 0 {
+			queue = append(queue, a)
+		}
+	}
+
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	type result struct {
+		a   *Action
+		err error
+	}
+	work := make(chan *Action)
+	results := make(chan result)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			for a := range work {
+				var err error
+				if a.Run != nil {
+					err = a.Run()
+				}
+				results <- result{a, err}
+			}
+		}()
+	}
+	defer close(work)
+
+	var running, failed int
+	for len(queue) > 0 && (failed == 0 || keepGoing) || running > 0 {
+		var next *Action
+		var send chan *Action // nil, unless there is an action to dispatch
+		if len(queue) > 0 && (failed == 0 || keepGoing) {
+			next = queue[0]
+			send = work
+		}
+
+		select {
+		case send <- next:
+			queue = queue[1:]
+			running++
+		case r := <-results:
+			running--
+			if r.err != nil {
+				// report the error in a single write so the output of
+				// concurrently failing actions is not interleaved.
+				fmt.Fprintf(os.Stderr, "# %s\n%v\n", r.a.Name, r.err)
+				failed++
+				continue
+			}
+			r.a.done = true
+			for _, d := range dependents[r.a] {
+				pending[d]--
+				if pending[d] == 0 {
+					queue = append(queue, d)
+				}
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d action(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// testGraph builds an action graph from deps, the names of the actions
+// each action depends on. Actions named in fail return an error. The
+// names of the actions run are recorded in ran.
+type testGraph struct {
+	mu      sync.Mutex
+	actions map[string]*Action
+	ran     []string
+	errs    []string // actions run before their dependencies completed
+}
+
+func newTestGraph(deps map[string][]string, fail ...string) *testGraph {
+	g := &testGraph{actions: make(map[string]*Action)}
+	failing := make(map[string]bool)
+	for _, name := range fail {
+		failing[name] = true
+	}
+	var action func(name string) *Action
+	action = func(name string) *Action {
+		if a, ok := g.actions[name]; ok {
+			return a
+		}
+		a := &Action{Name: name}
+		g.actions[name] = a
+		for _, d := range deps[name] {
+			a.Deps = append(a.Deps, action(d))
+		}
+		a.Run = func() error {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.ran = append(g.ran, name)
+			for _, d := range a.Deps {
+				if !d.done {
+					g.errs = append(g.errs, name+" ran before "+d.Name)
+				}
+			}
+			if failing[name] {
+				return errors.New(name + " failed")
+			}
+			return nil
+		}
+		return a
+	}
+	for name := range deps {
+		action(name)
+	}
+	return g
+}
+
+func TestExecute(t *testing.T) {
+	// root depends on c and d; c on a, d on b.
+	deps := map[string][]string{
+		"root": {"c", "d"},
+		"c":    {"a"},
+		"d":    {"b"},
+	}
+
+	tests := []struct {
+		jobs      int
+		keepGoing bool
+		fail      []string
+		ran       []string // actions run, blank to skip the check
+		notRun    []string // actions which must not run
+		err       string
+	}{
+		{jobs: 1, ran: []string{"a", "b", "c", "d", "root"}},
+		{jobs: 4, ran: []string{"a", "b", "c", "d", "root"}},
+		{jobs: 0, ran: []string{"a", "b", "c", "d", "root"}}, // at least one worker
+
+		// a failure stops further actions from starting.
+		{jobs: 1, fail: []string{"a"}, ran: []string{"a"}, err: "1 action(s) failed"},
+		{jobs: 4, fail: []string{"a"}, notRun: []string{"c", "root"}, err: "1 action(s) failed"},
+
+		// with -k, everything not depending on a failure is run.
+		{jobs: 1, keepGoing: true, fail: []string{"a"}, ran: []string{"a", "b", "d"}, err: "1 action(s) failed"},
+		{jobs: 4, keepGoing: true, fail: []string{"a"}, ran: []string{"a", "b", "d"}, err: "1 action(s) failed"},
+		{jobs: 4, keepGoing: true, fail: []string{"a", "b"}, ran: []string{"a", "b"}, err: "2 action(s) failed"},
+		{jobs: 4, keepGoing: true, fail: []string{"root"}, ran: []string{"a", "b", "c", "d", "root"}, err: "1 action(s) failed"},
+	}
+	for i, tt := range tests {
+		g := newTestGraph(deps, tt.fail...)
+		err := Execute(g.actions["root"], tt.jobs, tt.keepGoing)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%d: Execute: %v", i, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("%d: Execute: got error %v, want %q", i, err, tt.err)
+		}
+		for _, e := range g.errs {
+			t.Errorf("%d: %s", i, e)
+		}
+
+		sort.Strings(g.ran)
+		if tt.ran != nil && !reflect.DeepEqual(g.ran, tt.ran) {
+			t.Errorf("%d: ran %v, want %v", i, g.ran, tt.ran)
+		}
+		for _, name := range tt.notRun {
+			if contains(g.ran, name) {
+				t.Errorf("%d: ran %v, want %s not run", i, g.ran, name)
+			}
+		}
+
+		// only the actions which succeeded are done.
+		for name, a := range g.actions {
+			if want := contains(g.ran, name) && !contains(tt.fail, name); a.done != want {
+				t.Errorf("%d: %s done is %v, want %v", i, name, a.done, want)
+			}
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+
+	"github.com/constabulary/kang"
+)
+
+var BuildCmd = &Command{
+	Name:      "build",
+	UsageLine: "build [build flags] [-o output] [-os goos] [-arch goarch] [packages]",
+	Short:     "build the packages in the project",
+	Long: `
+Build compiles the named packages, by default every package in the
+project, along with their dependencies. Compiled packages are cached
+in .kang/pkg, commands are linked into the project root.
+
+Packages are named by directory, relative to the current directory,
+or by import path, either of a package in the project or of a package
+provided by a dependency listed in the .kangfile. Directories and
+project import paths ending in /... name every package below them,
+so ./... names the packages in and below the current directory.
+
+Packages which do not depend on each other are compiled in parallel.
+The -j flag sets the number of packages built concurrently, it defaults
+to the number of CPUs. Build stops starting new work after the first
+failure; with -k it builds everything not depending on a failed package.
+
+Intermediate files are written to a temporary work directory which is
+removed when build exits. The -work flag prints its name and keeps it.
+
+The -f flag rebuilds every package outside the standard library, even
+if it is up to date. The -race flag enables the race detector. The
+-gcflags and -ldflags flags pass extra flags to the compiler and linker,
+and -tags adds build tags used to select source files; a tag may hold
+letters, digits and underscores. Packages built with different -race
+or -tags settings are cached separately.
+
+The -o flag links the command to output rather than the project root.
+It may only be used when a single command is named.
+
+The -os and -arch flags, or the $GOOS and $GOARCH environment variables,
+select the target platform. When cross compiling, the standard library
+is compiled for the target into .kang/pkg unless it is already installed
+in $GOROOT, and command binaries are suffixed with the target platform.
+
+Build can be run from any directory inside the project.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		addBuildFlags(fs)
+		addTargetFlags(fs)
+		fs.StringVar(&buildOutput, "o", "", "link the command to `output`")
+	},
+	Run: runBuild,
+}
+
+var buildOutput string // -o
+
+var targetOS, targetArch string // -os, -arch
+
+// addTargetFlags registers the flags which select the target platform.
+func addTargetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&targetOS, "os", "", "target operating system, defaults to $GOOS or the host")
+	fs.StringVar(&targetArch, "arch", "", "target architecture, defaults to $GOARCH or the host")
+}
+
+func init() {
+	registerCommand(BuildCmd)
+}
+
+func runBuild(args []string) error {
+	proj := openProject()
+	ctx := newContext(proj)
+	pkgs, lock, err := loadPackages(proj, ctx, args)
+	if err != nil {
+		return err
+	}
+	if err := lock.write(); err != nil {
+		return err
+	}
+	if buildOutput != "" {
+		if err := setOutput(pkgs, buildOutput); err != nil {
+			return err
+		}
+	}
+	return buildAll(pkgs...)
+}
+
+// loadPackages loads the packages of proj, and their dependencies, for
+// the target described by ctx. It returns the packages named by patterns,
+// see matchPackages, and the lock of the dependencies, see loadDependencies.
+func loadPackages(proj *project, ctx *kang.Context, patterns []string) ([]*kang.Package, *lock, error) {
+	bctx := buildContext(ctx)
+	srcs := loadSources(bctx, proj.prefix, proj.rootdir)
+	for _, src := range srcs {
+		fmt.Fprintf(os.Stderr, "loaded %s (%s)\n", src.ImportPath, src.Name)
+	}
+
+	paths, err := matchPackages(proj, srcs, patterns)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// only the packages named, and the project packages they import,
+	// have their dependencies loaded. Standard library packages are
+	// loaded from $GOROOT.
+	var deps []string
+	var std []*build.Package
+	for _, p := range paths {
+		switch {
+		case hasPathPrefix(p, proj.prefix):
+		case stdlib[p]:
+			std = append(std, importStdlib(bctx, p, proj.rootdir))
+		default:
+			deps = append(deps, p)
+		}
+	}
+	srcs = importedSources(srcs, paths, false)
+	srcs, lock := loadDependencies(bctx, proj.rootdir, proj.kf, deps, srcs...)
+	srcs = append(srcs, std...)
+
+	byPath := make(map[string]*kang.Package)
+	for _, pkg := range transform(ctx, srcs...) {
+		byPath[pkg.ImportPath] = pkg
+	}
+	var pkgs []*kang.Package
+	for _, p := range paths {
+		pkg, ok := byPath[p]
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: package is not in the project, its dependencies, or the standard library", p)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, lock, nil
+}
+
+// importedSources returns the packages of srcs, the packages of the project,
+// whose import paths are among paths, and those of srcs they import,
+// directly or indirectly. If tests is true, the imports of the test files
+// of the packages named by paths are included too. Only these need their
+// dependencies loaded.
+func importedSources(srcs []*build.Package, paths []string, tests bool) []*build.Package {
+	byPath := make(map[string]*build.Package)
+	for _, src := range srcs {
+		byPath[src.ImportPath] = src
+	}
+	var imported []*build.Package
+	seen := make(map[string]bool)
+	var walk func(path string)
+	walk = func(path string) {
+		src, ok := byPath[path]
+		if !ok || seen[path] {
+			return
+		}
+		seen[path] = true
+		for _, i := range src.Imports {
+			walk(i)
+		}
+		imported = append(imported, src)
+	}
+	for _, p := range paths {
+		walk(p)
+		if src, ok := byPath[p]; ok && tests {
+			for _, i := range stringList(src.TestImports, src.XTestImports) {
+				walk(i)
+			}
+		}
+	}
+	return imported
+}
+
+// buildAll builds the packages which are stale in pkgs and their
+// dependencies, and links the commands among them.
+func buildAll(pkgs ...*kang.Package) error {
+	computeStale(pkgs...)
+
+	targets := make(map[*kang.Package]*Action)
+	root, err := buildPackages(targets, pkgs...)
+	if err != nil {
+		return err
+	}
+	return Execute(root, buildJobs, keepGoing)
+}
+
+// setOutput directs the command in pkgs to be linked to output. It is an
+// error if pkgs does not contain exactly one command.
+func setOutput(pkgs []*kang.Package, output string) error {
+	var mains []*kang.Package
+	for _, pkg := range pkgs {
+		if pkg.Main {
+			mains = append(mains, pkg)
+		}
+	}
+	if len(mains) != 1 {
+		return fmt.Errorf("-o requires a single command, found %d", len(mains))
+	}
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	mains[0].Output = output
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	cacheAge   time.Duration // -age
+	cachePrint bool          // -n
+)
+
+var CacheCmd = &Command{
+	Name:      "cache",
+	UsageLine: "cache gc [-age duration] [-n]",
+	Short:     "manage the dependency cache",
+	Long: `
+Cache gc removes entries from the project's dependency cache, in
+.kang/cache, which are no longer required by the .kangfile, nor by
+the .kangfiles of the dependencies it requires.
+
+Each use of a cache entry by build, test or get updates its
+modification time. With -age, entries which have not been used for
+longer than the given duration are removed too, even if they are
+still required; they are fetched again when next needed.
+
+The -n flag prints the entries which would be removed, but does not
+remove them.
+`,
+	AddFlags: addCacheFlags,
+	Run:      runCache,
+}
+
+// addCacheFlags registers the flags of cache gc. The current values of
+// the flags are their defaults, so flags may be given either side of gc.
+func addCacheFlags(fs *flag.FlagSet) {
+	fs.DurationVar(&cacheAge, "age", cacheAge, "also remove entries unused for longer than `duration`")
+	fs.BoolVar(&cachePrint, "n", cachePrint, "print the entries to remove, but do not remove them")
+}
+
+func init() {
+	registerCommand(CacheCmd)
+}
+
+func runCache(args []string) error {
+	if len(args) < 1 || args[0] != "gc" {
+		return fmt.Errorf("usage: kang cache gc [-age duration] [-n]")
+	}
+	fs := flag.NewFlagSet("cache gc", flag.ExitOnError)
+	addCacheFlags(fs)
+	fs.Parse(args[1:])
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: kang cache gc [-age duration] [-n]")
+	}
+
+	proj := openProject()
+	g, err := loadRequirements(proj.rootdir, proj.kf, false)
+	if err != nil {
+		return err
+	}
+	required := make(map[string]bool)
+	for _, dir := range g.entries {
+		required[dir] = true
+	}
+	return gcCache(filepath.Join(proj.rootdir, ".kang", "cache"), required, cacheAge)
+}
+
+// gcCache removes the entries of the cache in dir which are not required,
+// or, if age is not zero, have not been used for longer than age.
+// Temporary directories left behind by interrupted fetches are removed
+// once they are older than an hour.
+func gcCache(dir string, required map[string]bool, age time.Duration) error {
+	buckets, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil // nothing cached
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var removed int
+	remove := func(path string) error {
+		fmt.Println("rm -rf", path)
+		removed++
+		if cachePrint {
+			return nil
+		}
+		return os.RemoveAll(path)
+	}
+
+	for _, b := range buckets {
+		bucket := filepath.Join(dir, b.Name())
+		if strings.HasPrefix(b.Name(), "tmp-") {
+			// see fetch.
+			if now.Sub(b.ModTime()) > time.Hour {
+				if err := remove(bucket); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !b.IsDir() {
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(bucket)
+		if err != nil {
+			return err
+		}
+		var kept int
+		for _, e := range entries {
+			entry := filepath.Join(bucket, e.Name())
+			unused := age > 0 && now.Sub(e.ModTime()) > age
+			if required[entry] && !unused {
+				kept++
+				continue
+			}
+			if err := remove(entry); err != nil {
+				return err
+			}
+		}
+		if kept == 0 && !cachePrint {
+			if err := os.Remove(bucket); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Printf("removed %d cache entries\n", removed)
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/constabulary/kang"
+)
+
+var cleanPrint bool // -n
+
+var CleanCmd = &Command{
+	Name:      "clean",
+	UsageLine: "clean [-n]",
+	Short:     "remove compiled packages and commands",
+	Long: `
+Clean removes the compiled packages in .kang/pkg, and the command
+binaries build linked into the project root, including those built
+for other platforms. The dependency cache is left alone, see
+'kang cache gc'.
+
+The -n flag prints the files which would be removed, but does not
+remove them.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&cleanPrint, "n", false, "print the files to remove, but do not remove them")
+	},
+	Run: runClean,
+}
+
+func init() {
+	registerCommand(CleanCmd)
+}
+
+func runClean(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: kang clean [-n]")
+	}
+
+	proj := openProject()
+	pkgdir := filepath.Join(proj.rootdir, ".kang", "pkg")
+	targets := []string{pkgdir}
+	tagsets, err := pkgTagSets(pkgdir)
+	if err != nil {
+		return err
+	}
+
+	bctx := build.Default
+	for _, src := range loadSources(&bctx, proj.prefix, proj.rootdir) {
+		if src.Name != "main" {
+			continue
+		}
+		bins, err := binaries(proj.rootdir, path.Base(src.ImportPath), tagsets)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, bins...)
+	}
+
+	for _, target := range targets {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			continue
+		}
+		fmt.Println("rm -rf", target)
+		if cleanPrint {
+			continue
+		}
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// binaries returns the executables in dir linked from a command named
+// name; name itself, and name suffixed with a platform and build tags,
+// see kang.Package.Binfile and isBinfile.
+func binaries(dir, name string, tagsets map[string]bool) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, name+"*"))
+	if err != nil {
+		return nil, err
+	}
+	var bins []string
+	for _, m := range matches {
+		if !isBinfile(filepath.Base(m), name, tagsets) {
+			continue
+		}
+		fi, err := os.Lstat(m)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.Mode().IsRegular() || fi.Mode()&0111 == 0 {
+			// not something we linked.
+			continue
+		}
+		bins = append(bins, m)
+	}
+	return bins, nil
+}
+
+// pkgTagSets returns the sets of build tags, dash separated, packages in
+// pkgdir have been built with. The directory of each target is named
+// goos_goarch[-tag...], see kang.Context.
+func pkgTagSets(pkgdir string) (map[string]bool, error) {
+	dirs, err := ioutil.ReadDir(pkgdir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	tagsets := make(map[string]bool)
+	for _, fi := range dirs {
+		if i := strings.Index(fi.Name(), "-"); fi.IsDir() && i >= 0 {
+			tagsets[fi.Name()[i+1:]] = true
+		}
+	}
+	return tagsets, nil
+}
+
+// isBinfile reports whether file is a name Binfile gives the command
+// name; one of
+//
+//	name
+//	name-goos-goarch[-tag...]
+//	name-tag[-tag...]
+//
+// optionally followed by .exe. Build tags are sorted and unique, see
+// kang.Tags. As a command may itself be named with dashes, the platform
+// must be one Go knows, and tags alone must be one of tagsets, the sets
+// of tags packages of the project have been built with.
+func isBinfile(file, name string, tagsets map[string]bool) bool {
+	file = strings.TrimSuffix(file, ".exe")
+	if file == name {
+		return true
+	}
+	if !strings.HasPrefix(file, name+"-") {
+		return false
+	}
+	suffix := strings.TrimPrefix(file, name+"-")
+	if tagsets[suffix] {
+		return true
+	}
+	parts := strings.Split(suffix, "-")
+	if len(parts) < 2 || !knownOS[parts[0]] || !knownArch[parts[1]] {
+		return false
+	}
+	for _, p := range parts[2:] {
+		if !kang.ValidTag(p) {
+			return false
+		}
+	}
+	return sortedTags(parts[2:])
+}
+
+// sortedTags reports whether tags is in strictly increasing order.
+func sortedTags(tags []string) bool {
+	for i := 1; i < len(tags); i++ {
+		if tags[i-1] >= tags[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// knownOS and knownArch are the values of GOOS and GOARCH known to Go.
+var knownOS = stringSet(
+	"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos",
+	"ios", "js", "linux", "nacl", "netbsd", "openbsd", "plan9", "solaris",
+	"wasip1", "windows", "zos",
+)
+
+var knownArch = stringSet(
+	"386", "amd64", "amd64p32", "arm", "armbe", "arm64", "arm64be",
+	"loong64", "mips", "mipsle", "mips64", "mips64le", "mips64p32",
+	"mips64p32le", "ppc", "ppc64", "ppc64le", "riscv", "riscv64", "s390",
+	"s390x", "sparc", "sparc64", "wasm",
+)
+
+func stringSet(v ...string) map[string]bool {
+	m := make(map[string]bool)
+	for _, s := range v {
+		m[s] = true
+	}
+	return m
+}
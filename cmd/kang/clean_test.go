@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsBinfile(t *testing.T) {
+	tagsets := map[string]bool{"race": true, "netgo-race": true}
+	tests := []struct {
+		file, name string
+		want       bool
+	}{
+		{"kang", "kang", true},
+		{"kang.exe", "kang", true},
+		{"kang-linux-amd64", "kang", true},
+		{"kang-windows-386.exe", "kang", true},
+		{"kang-linux-amd64-netgo-race", "kang", true},
+		{"kang-race", "kang", true},
+		{"kang-netgo-race", "kang", true},
+		{"deploy-prod", "deploy", false},               // not a tag set the project was built with
+		{"kang-race-netgo", "kang", false},             // tags are sorted
+		{"kang-linux-amd64-race-netgo", "kang", false}, // tags are sorted
+		{"kang-linux-amd64-race-race", "kang", false},  // tags are unique
+		{"kang-linux-amd64-a.b", "kang", false},        // invalid tag
+		{"kang-plan10-amd64", "kang", false},           // unknown GOOS
+		{"kang-linux-x86", "kang", false},              // unknown GOARCH
+		{"kang-linux", "kang", false},
+		{"kangaroo", "kang", false},
+		{"kang-", "kang", false},
+		{"deploy-prod-linux-amd64", "deploy-prod", true},
+		{"deploy-prod-linux-amd64", "deploy", false},
+	}
+	for _, tt := range tests {
+		if got := isBinfile(tt.file, tt.name, tagsets); got != tt.want {
+			t.Errorf("isBinfile(%q, %q): got %v, want %v", tt.file, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPkgTagSets(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"linux_amd64", "linux_amd64-race", "windows_386-netgo-race"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := pkgTagSets(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"race": true, "netgo-race": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pkgTagSets: got %v, want %v", got, want)
+	}
+
+	// a project which has not been built has none.
+	got, err = pkgTagSets(filepath.Join(dir, "missing"))
+	if err != nil || len(got) != 0 {
+		t.Errorf("pkgTagSets of missing directory: got %v, %v, want none", got, err)
+	}
+}
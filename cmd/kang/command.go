@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// Command represents a kang subcommand.
+type Command struct {
+	// Name of the command, as typed on the command line.
+	Name string
+
+	// UsageLine is the one line usage message.
+	// It must begin with the command name.
+	UsageLine string
+
+	// Short is the description shown in the 'kang help' output.
+	Short string
+
+	// Long is the message shown in the 'kang help <command>' output.
+	Long string
+
+	// AddFlags, if not nil, registers the command's flags.
+	AddFlags func(fs *flag.FlagSet)
+
+	// Run runs the command. args are the arguments remaining
+	// after the command's flags have been parsed.
+	Run func(args []string) error
+}
+
+// FlagSet returns a flag.FlagSet populated with the flags of this command.
+func (c *Command) FlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.Name, flag.ExitOnError)
+	fs.Usage = func() {
+		printCommandUsage(os.Stderr, c, fs)
+	}
+	if c.AddFlags != nil {
+		c.AddFlags(fs)
+	}
+	return fs
+}
+
+// commands holds the registered subcommands, indexed by name.
+var commands = make(map[string]*Command)
+
+// registerCommand adds cmd to the set of known commands.
+func registerCommand(cmd *Command) {
+	if _, ok := commands[cmd.Name]; ok {
+		panic("command " + cmd.Name + " registered twice")
+	}
+	commands[cmd.Name] = cmd
+}
+
+// sortedCommands returns the registered commands sorted by name.
+func sortedCommands() []*Command {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var cmds []*Command
+	for _, name := range names {
+		cmds = append(cmds, commands[name])
+	}
+	return cmds
+}
+
+var usageTemplate = `kang builds Go projects described by a .kangfile, no $GOPATH required.
+
+Usage:
+
+	kang command [arguments]
+
+The commands are:
+{{range .}}
+	{{.Name | printf "%-12s"}} {{.Short}}{{end}}
+
+Use "kang help [command]" for more information about a command.
+`
+
+func printUsage(w io.Writer) {
+	t := template.Must(template.New("usage").Parse(usageTemplate))
+	if err := t.Execute(w, sortedCommands()); err != nil {
+		panic(err)
+	}
+}
+
+func printCommandUsage(w io.Writer, cmd *Command, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "usage: kang %s\n\n", cmd.UsageLine)
+	if long := strings.TrimSpace(cmd.Long); long != "" {
+		fmt.Fprintf(w, "%s\n\n", long)
+	}
+	var hasFlags bool
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w, "Flags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+func usage() {
+	printUsage(os.Stderr)
+	os.Exit(2)
+}
+
+var HelpCmd = &Command{
+	Name:      "help",
+	UsageLine: "help [command]",
+	Short:     "show documentation for a command",
+	Long: `
+Help prints the list of kang commands, or if a command is named,
+the usage and documentation for that command.
+`,
+	Run: runHelp,
+}
+
+func init() {
+	registerCommand(HelpCmd)
+}
+
+func runHelp(args []string) error {
+	switch len(args) {
+	case 0:
+		printUsage(os.Stdout)
+		return nil
+	case 1:
+		cmd, ok := commands[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown help topic %q, run 'kang help'", args[0])
+		}
+		printCommandUsage(os.Stdout, cmd, cmd.FlagSet())
+		return nil
+	default:
+		return fmt.Errorf("usage: kang help [command]")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// commitFile is the name of the file, at the root of a cache entry,
+// which records the commit the entry was checked out from.
+const commitFile = ".commit"
+
+// fetch populates the cache entry dir with the source of the dependency
+// rooted at prefix, checked out at the revision described by kind and arg.
+// If repo is blank the repository is derived from prefix.
+func fetch(dir, prefix, kind, arg, repo string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil // already cached
+	}
+
+	root := prefix
+	if repo == "" {
+		var err error
+		root, repo, err = repoRoot(prefix)
+		if err != nil {
+			return err
+		}
+	} else if r, ok := knownRoot(prefix); ok && r != "" {
+		root = r
+	}
+	return fetchRepo(dir, root, prefix, kind, arg, repo)
+}
+
+// fetchRepo populates the cache entry dir by cloning repo, whose root
+// has the import path root, and checking out the revision described by
+// kind and arg. prefix must be root, or a directory below it.
+//
+// The source is cloned into a temporary directory alongside the cache
+// entry, then renamed into place, so other kang processes never observe
+// a partially populated cache entry.
+func fetchRepo(dir, root, prefix, kind, arg, repo string) error {
+	fmt.Fprintln(os.Stderr, "fetching", prefix, "@", kind+"="+arg, "from", repo)
+
+	cachedir := filepath.Dir(filepath.Dir(dir))
+	if err := os.MkdirAll(cachedir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(cachedir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, filepath.FromSlash(root))
+	if err := os.MkdirAll(filepath.Dir(src), 0755); err != nil {
+		return err
+	}
+	if err := git(tmp, "clone", "--quiet", repo, src); err != nil {
+		return err
+	}
+	commit, err := resolveRevision(src, kind, arg)
+	if err != nil {
+		return fmt.Errorf("%s: %v", prefix, err)
+	}
+	if err := git(src, "checkout", "--quiet", "--detach", commit); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(filepath.Join(tmp, filepath.FromSlash(prefix))); err != nil || !fi.IsDir() {
+		return fmt.Errorf("%s: no such directory in repository %s at %s=%s", prefix, repo, kind, arg)
+	}
+
+	// the cache holds source only, record the commit
+	// and discard the repository metadata.
+	if err := os.RemoveAll(filepath.Join(src, ".git")); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, commitFile), []byte(commit+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, err := os.Stat(dir); err == nil {
+			return nil // another kang process populated the entry first
+		}
+		return err
+	}
+	return nil
+}
+
+// resolveRevision returns the full commit hash in the repository at dir
+// for the revision described by kind and arg. Versions are matched against
+// tags of the form vX.Y.Z, then X.Y.Z.
+func resolveRevision(dir, kind, arg string) (string, error) {
+	var candidates []string
+	switch kind {
+	case "version":
+		candidates = []string{"v" + arg, arg}
+	case "tag", "commit":
+		candidates = []string{arg}
+	default:
+		return "", fmt.Errorf("unknown revision kind %q", kind)
+	}
+	for _, rev := range candidates {
+		cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err == nil {
+			return strings.TrimSpace(string(out)), nil
+		}
+	}
+	return "", fmt.Errorf("cannot find %s=%s", kind, arg)
+}
+
+// readCommit returns the commit recorded in the cache entry dir.
+func readCommit(dir string) (string, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(dir, commitFile))
+	return strings.TrimSpace(string(buf)), err
+}
+
+// git runs git with args in dir.
+func git(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// repoRoot returns the import path of the root of the repository which
+// contains the package path, along with the URL of the repository.
+// Well known hosting sites are recognised by their path, for other
+// import paths the <meta name="go-import"> tag is consulted, as go get
+// does.
+func repoRoot(path string) (root, url string, err error) {
+	if root, ok := knownRoot(path); ok {
+		if root == "" {
+			return "", "", fmt.Errorf("invalid %s import path %q", path[:strings.Index(path+"/", "/")], path)
+		}
+		return root, "https://" + root, nil
+	}
+
+	resp, err := http.Get("https://" + path + "?go-get=1")
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	imports, err := parseMetaGoImports(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for _, imp := range imports {
+		if imp.vcs != "git" {
+			continue
+		}
+		if path == imp.prefix || strings.HasPrefix(path, imp.prefix+"/") {
+			return imp.prefix, imp.repo, nil
+		}
+	}
+	return "", "", fmt.Errorf("cannot find a git repository for %s", path)
+}
+
+// knownRoot returns the import path of the root of the repository which
+// contains path, if path is on a well known hosting site. The root is
+// blank if path is too short to name a repository on the site.
+func knownRoot(path string) (string, bool) {
+	elem := strings.Split(path, "/")
+	switch elem[0] {
+	case "github.com", "bitbucket.org", "gitlab.com":
+		if len(elem) < 3 {
+			return "", true
+		}
+		return strings.Join(elem[:3], "/"), true
+	}
+	return "", false
+}
+
+type metaImport struct {
+	prefix, vcs, repo string
+}
+
+// parseMetaGoImports returns the go-import meta tags found in the
+// head of the HTML document read from r.
+func parseMetaGoImports(r io.Reader) ([]metaImport, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var imports []metaImport
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") {
+			continue
+		}
+		if attrValue(e.Attr, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(attrValue(e.Attr, "content")); len(f) == 3 {
+			imports = append(imports, metaImport{
+				prefix: f[0],
+				vcs:    f[1],
+				repo:   f[2],
+			})
+		}
+	}
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testRemote is a bare git repository holding a commit for each of its
+// tags; each commit writes the tag's name to a.go and sub/b.go.
+type testRemote struct {
+	dir     string            // path of the bare repository
+	commits map[string]string // commit of each tag
+}
+
+// newTestRemote creates a bare repository below root with a commit for
+// each of tags, in order.
+func newTestRemote(t *testing.T, root string, tags ...string) *testRemote {
+	work := filepath.Join(root, "work")
+	r := &testRemote{
+		dir:     filepath.Join(root, "remote.git"),
+		commits: make(map[string]string),
+	}
+	run := func(dir string, args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=kang", "GIT_AUTHOR_EMAIL=kang@example.com",
+			"GIT_COMMITTER_NAME=kang", "GIT_COMMITTER_EMAIL=kang@example.com",
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run(root, "init", "--quiet", "--bare", r.dir)
+	run(work, "init", "--quiet")
+	for _, tag := range tags {
+		if err := ioutil.WriteFile(filepath.Join(work, "a.go"), []byte("package a // "+tag+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(work, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(work, "sub", "b.go"), []byte("package b // "+tag+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		run(work, "add", "a.go", "sub/b.go")
+		run(work, "commit", "--quiet", "-m", tag)
+		run(work, "tag", tag)
+		r.commits[tag] = run(work, "rev-parse", "HEAD")
+	}
+	run(work, "push", "--quiet", "--tags", r.dir)
+	return r
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kang-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestResolveRevision(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	r := newTestRemote(t, root, "v1.0.0", "2.0.0", "release")
+
+	tests := []struct {
+		kind, arg string
+		want      string // tag of the expected commit, blank for an error
+	}{
+		{"version", "1.0.0", "v1.0.0"},
+		{"version", "2.0.0", "2.0.0"}, // tag without the v prefix
+		{"version", "3.0.0", ""},
+		{"tag", "release", "release"},
+		{"tag", "v1.0.0", "v1.0.0"},
+		{"tag", "1.0.0", ""},
+		{"commit", r.commits["2.0.0"], "2.0.0"},
+		{"commit", r.commits["2.0.0"][:7], "2.0.0"},
+		{"commit", "0000000000000000000000000000000000000000", ""},
+		{"branch", "master", ""},
+	}
+	for _, tt := range tests {
+		got, err := resolveRevision(r.dir, tt.kind, tt.arg)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("resolveRevision(%s=%s): got %s, want error", tt.kind, tt.arg, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveRevision(%s=%s): %v", tt.kind, tt.arg, err)
+			continue
+		}
+		if want := r.commits[tt.want]; got != want {
+			t.Errorf("resolveRevision(%s=%s): got %s, want %s (%s)", tt.kind, tt.arg, got, want, tt.want)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	r := newTestRemote(t, root, "v1.0.0", "v1.1.0")
+	const prefix = "example.com/a"
+
+	tests := []struct {
+		kind, arg string
+		want      string // tag of the expected commit, blank for an error
+	}{
+		{"version", "1.0.0", "v1.0.0"},
+		{"tag", "v1.1.0", "v1.1.0"},
+		{"commit", r.commits["v1.0.0"], "v1.0.0"},
+		{"version", "9.9.9", ""},
+	}
+	for _, tt := range tests {
+		dir := cacheDir(root, prefix+tt.kind+"="+tt.arg)
+		err := fetch(dir, prefix, tt.kind, tt.arg, r.dir)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("fetch(%s=%s): want error", tt.kind, tt.arg)
+			}
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("fetch(%s=%s): failed fetch left cache entry %s", tt.kind, tt.arg, dir)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("fetch(%s=%s): %v", tt.kind, tt.arg, err)
+			continue
+		}
+
+		commit, err := readCommit(dir)
+		if err != nil {
+			t.Errorf("fetch(%s=%s): %v", tt.kind, tt.arg, err)
+		} else if want := r.commits[tt.want]; commit != want {
+			t.Errorf("fetch(%s=%s): recorded commit %s, want %s", tt.kind, tt.arg, commit, want)
+		}
+		src := filepath.Join(dir, filepath.FromSlash(prefix))
+		buf, err := ioutil.ReadFile(filepath.Join(src, "a.go"))
+		if err != nil {
+			t.Errorf("fetch(%s=%s): %v", tt.kind, tt.arg, err)
+		} else if want := "package a // " + tt.want + "\n"; string(buf) != want {
+			t.Errorf("fetch(%s=%s): a.go is %q, want %q", tt.kind, tt.arg, buf, want)
+		}
+		if _, err := os.Stat(filepath.Join(src, ".git")); !os.IsNotExist(err) {
+			t.Errorf("fetch(%s=%s): cache entry holds repository metadata", tt.kind, tt.arg)
+		}
+
+		// a cached entry is not fetched again.
+		if err := fetch(dir, prefix, tt.kind, tt.arg, filepath.Join(root, "missing.git")); err != nil {
+			t.Errorf("fetch(%s=%s) of cached entry: %v", tt.kind, tt.arg, err)
+		}
+	}
+}
+
+func TestFetchSubdirectory(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+	r := newTestRemote(t, root, "v1.0.0")
+	const rootPath = "example.com/a"
+
+	// the repository is cloned at its root, the prefix is below it.
+	const prefix = rootPath + "/sub"
+	dir := cacheDir(root, prefix+"tag=v1.0.0")
+	if err := fetchRepo(dir, rootPath, prefix, "tag", "v1.0.0", r.dir); err != nil {
+		t.Fatalf("fetchRepo(%s): %v", prefix, err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(prefix), "b.go"))
+	if err != nil {
+		t.Errorf("fetchRepo(%s): %v", prefix, err)
+	} else if want := "package b // v1.0.0\n"; string(buf) != want {
+		t.Errorf("fetchRepo(%s): b.go is %q, want %q", prefix, buf, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(rootPath), ".git")); !os.IsNotExist(err) {
+		t.Errorf("fetchRepo(%s): cache entry holds repository metadata", prefix)
+	}
+
+	// a prefix with no directory in the repository is an error.
+	const missing = rootPath + "/missing"
+	dir = cacheDir(root, missing+"tag=v1.0.0")
+	if err := fetchRepo(dir, rootPath, missing, "tag", "v1.0.0", r.dir); err == nil {
+		t.Errorf("fetchRepo(%s): want error", missing)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("fetchRepo(%s): failed fetch left cache entry %s", missing, dir)
+	}
+}
+
+func TestKnownRoot(t *testing.T) {
+	tests := []struct {
+		path, root string
+		ok         bool
+	}{
+		{"github.com/pkg/errors", "github.com/pkg/errors", true},
+		{"github.com/pkg/errors/sub/dir", "github.com/pkg/errors", true},
+		{"gitlab.com/a/b/c", "gitlab.com/a/b", true},
+		{"github.com/pkg", "", true},
+		{"golang.org/x/net/http2", "", false},
+	}
+	for _, tt := range tests {
+		root, ok := knownRoot(tt.path)
+		if root != tt.root || ok != tt.ok {
+			t.Errorf("knownRoot(%q): got %q, %v, want %q, %v", tt.path, root, ok, tt.root, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var fmtPrint bool // -n
+
+var FmtKangfileCmd = &Command{
+	Name:      "fmt-kangfile",
+	UsageLine: "fmt-kangfile [-n]",
+	Short:     "reformat the project's .kangfile",
+	Long: `
+Fmt-kangfile rewrites the project's .kangfile in canonical form.
+Comments and blank lines are preserved, the columns of consecutive
+declaration lines are aligned.
+
+The -n flag prints the formatted .kangfile to standard output rather
+than rewriting it.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&fmtPrint, "n", false, "print the result rather than rewriting the .kangfile")
+	},
+	Run: runFmtKangfile,
+}
+
+func init() {
+	registerCommand(FmtKangfileCmd)
+}
+
+func runFmtKangfile(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: kang fmt-kangfile [-n]")
+	}
+
+	path, err := findkangfile(cwd())
+	if err != nil {
+		return err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	f, err := ParseSyntaxFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Kangfile(); err != nil {
+		return fmt.Errorf("%s:%v", path, err)
+	}
+
+	if fmtPrint {
+		_, err := os.Stdout.Write(f.Format())
+		return err
+	}
+	return f.WriteFile(path)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var getRepo string // -repo
+
+var GetCmd = &Command{
+	Name:      "get",
+	UsageLine: "get [-repo url] importpath[@revision]...",
+	Short:     "add or update a dependency",
+	Long: `
+Get fetches the repository containing each named package into the
+kang cache and records it as a dependency in the project's .kangfile.
+
+The revision following the @ selects what to check out:
+
+	github.com/pkg/errors@0.8.0          version=0.8.0 (tag v0.8.0)
+	github.com/pkg/errors@tag:v0.8.0     tag=v0.8.0
+	github.com/pkg/errors@commit:645ef00 commit=645ef00
+
+If no revision is given, the current head of the repository's default
+branch is recorded as a commit.
+
+If the package is covered by a dependency already declared in the
+.kangfile, that dependency is updated in place. Otherwise a dependency
+for the root of the package's repository is added. The -repo flag
+overrides the repository URL and records it on the dependency line.
+
+The .kangfile is only rewritten once the project, and the named
+packages, load successfully with the new dependency.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		fs.StringVar(&getRepo, "repo", "", "fetch from `url` rather than the repository derived from the import path")
+	},
+	Run: runGet,
+}
+
+func init() {
+	registerCommand(GetCmd)
+}
+
+func runGet(args []string) error {
+	if len(args) == 0 || (getRepo != "" && len(args) > 1) {
+		return fmt.Errorf("usage: kang get [-repo url] importpath[@revision]...")
+	}
+
+	proj := openProject()
+	path := filepath.Join(proj.rootdir, ".kangfile")
+	f, err := ParseSyntaxFile(path)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, arg := range args {
+		importpath, d, err := getDependency(f, arg)
+		if err != nil {
+			return err
+		}
+		kind, rev := d.Revision()
+		if err := fetch(cacheDir(proj.rootdir, d.Prefix+kind+"="+rev), d.Prefix, kind, rev, d.Repo); err != nil {
+			return err
+		}
+		f.SetDependency(d)
+		paths = append(paths, importpath)
+	}
+
+	kf, err := f.Kangfile()
+	if err != nil {
+		return fmt.Errorf("%s:%v", path, err)
+	}
+
+	// verify the project, and the requested packages, load with the
+	// new dependencies before rewriting the .kangfile.
+	ctx := newContext(proj)
+	bctx := buildContext(ctx)
+	srcs := loadSources(bctx, proj.prefix, proj.rootdir)
+	_, lock := loadDependencies(bctx, proj.rootdir, kf, paths, srcs...)
+
+	if err := f.WriteFile(path); err != nil {
+		return err
+	}
+	if err := lock.write(); err != nil {
+		return err
+	}
+	for _, p := range paths {
+		fmt.Println("added", p)
+	}
+	return nil
+}
+
+// getDependency parses arg, of the form importpath[@revision], and returns
+// the import path and the dependency which provides it.
+func getDependency(f *File, arg string) (string, Dependency, error) {
+	importpath, rev := arg, ""
+	if i := strings.LastIndex(arg, "@"); i >= 0 {
+		importpath, rev = arg[:i], arg[i+1:]
+	}
+
+	// if a declared dependency provides importpath, update it.
+	kf, err := f.Kangfile()
+	if err != nil {
+		return "", Dependency{}, err
+	}
+	r, err := newResolver(kf)
+	if err != nil {
+		return "", Dependency{}, err
+	}
+	var d Dependency
+	if dep, err := r.resolve(importpath); err == nil {
+		d = Dependency{Prefix: dep.Prefix, Repo: dep.Repo}
+	}
+
+	if d.Prefix == "" {
+		d.Prefix = importpath
+		if getRepo == "" {
+			root, _, err := repoRoot(importpath)
+			if err != nil {
+				return "", d, err
+			}
+			d.Prefix = root
+		}
+	}
+	if getRepo != "" {
+		d.Repo = getRepo
+	}
+
+	switch {
+	case rev == "":
+		repo := d.Repo
+		if repo == "" {
+			var err error
+			if _, repo, err = repoRoot(d.Prefix); err != nil {
+				return "", d, err
+			}
+		}
+		commit, err := remoteHead(repo)
+		if err != nil {
+			return "", d, err
+		}
+		d.Commit = commit
+	case strings.HasPrefix(rev, "tag:"):
+		d.Tag = strings.TrimPrefix(rev, "tag:")
+	case strings.HasPrefix(rev, "commit:"):
+		d.Commit = strings.TrimPrefix(rev, "commit:")
+	default:
+		// versions are recorded without their leading v.
+		d.Version = strings.TrimPrefix(rev, "v")
+	}
+
+	if kind, rev := d.Revision(); rev == "" {
+		return "", d, fmt.Errorf("%s: missing %s", arg, kind)
+	}
+	return importpath, d, nil
+}
+
+// remoteHead returns the commit at the head of the default branch
+// of repo.
+func remoteHead(repo string) (string, error) {
+	out, err := exec.Command("git", "ls-remote", repo, "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git ls-remote %s: %v", repo, err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s: cannot determine HEAD", repo)
+	}
+	return fields[0], nil
+}
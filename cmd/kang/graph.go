@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/constabulary/kang"
+)
+
+var (
+	graphJSON bool   // -json
+	graphStd  bool   // -std
+	graphWhy  string // -why
+)
+
+var GraphCmd = &Command{
+	Name:      "graph",
+	UsageLine: "graph [-json] [-std] [-why package] [-race] [-tags tags] [-os goos] [-arch goarch] [packages]",
+	Short:     "print the package import graph",
+	Long: `
+Graph prints the import graph of the named packages, by default every
+package in the project, in Graphviz DOT format.
+
+Packages are grouped by where they come from; the project, or the
+dependency in the .kangfile, or in the .kangfile of a dependency, which
+provides them. Standard library packages are left out unless -std is
+given.
+
+The -json flag prints the graph as a JSON object instead, holding the
+groups and, for each package, its group and imports.
+
+The -why flag prints the shortest chain of imports from the named
+packages to the package, or to any package provided by the dependency,
+given, rather than the graph.
+
+    kang graph -why github.com/pkg/errors
+
+The -race, -tags, -os and -arch flags are those of build, they select
+the source files, and so the imports, of each package.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&graphJSON, "json", false, "print the graph in JSON format")
+		fs.BoolVar(&graphStd, "std", false, "include standard library packages")
+		fs.StringVar(&graphWhy, "why", "", "print the shortest import chain to `package`")
+		addContextFlags(fs)
+		addTargetFlags(fs)
+	},
+	Run: runGraph,
+}
+
+func init() {
+	registerCommand(GraphCmd)
+}
+
+// graphGroup is a set of packages with a common origin.
+type graphGroup struct {
+	Name       string   // the project or dependency import path prefix, or std
+	Revision   string   `json:",omitempty"` // kind=arg of a dependency
+	RequiredBy string   `json:",omitempty"` // dependency which required it, if not the project
+	Packages   []string // import paths of the packages in the group
+}
+
+// graphNode is a package in the import graph.
+type graphNode struct {
+	ImportPath string
+	Group      string   // Name of the group holding the package
+	Imports    []string `json:",omitempty"` // imports shown in the graph
+}
+
+// importGraph is the form of the graph printed by kang graph -json.
+type importGraph struct {
+	Groups   []*graphGroup
+	Packages []*graphNode
+}
+
+func runGraph(args []string) error {
+	proj := openProject()
+	ctx := newContext(proj)
+	roots, _, err := loadPackages(proj, ctx, args)
+	if err != nil {
+		return err
+	}
+	deps, err := resolveDependencies(proj.rootdir, proj.kf)
+	if err != nil {
+		return err
+	}
+
+	if graphWhy != "" {
+		return printWhy(os.Stdout, roots, graphWhy)
+	}
+
+	g, err := newImportGraph(proj, deps, roots)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if graphJSON {
+		buf, err := json.MarshalIndent(g, "", "\t")
+		if err != nil {
+			return err
+		}
+		w.Write(buf)
+		w.WriteString("\n")
+		return nil
+	}
+	writeDot(w, g)
+	return nil
+}
+
+// newImportGraph returns the graph of roots and their dependencies,
+// grouped by the project and the dependencies, deps, of proj.
+func newImportGraph(proj *project, deps []Dependency, roots []*kang.Package) (*importGraph, error) {
+	r, err := newResolver(&Kangfile{
+		Project:      proj.kf.Project,
+		Dependencies: deps,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var g importGraph
+	groups := make(map[string]*graphGroup)
+	group := func(name string) *graphGroup {
+		gr, ok := groups[name]
+		if !ok {
+			gr = &graphGroup{Name: name}
+			groups[name] = gr
+			g.Groups = append(g.Groups, gr)
+		}
+		return gr
+	}
+	group(proj.prefix)
+
+	for _, pkg := range withDeps(roots) {
+		if pkg.Standard && !graphStd {
+			continue
+		}
+		var gr *graphGroup
+		switch {
+		case pkg.Standard:
+			gr = group("std")
+		case hasPathPrefix(pkg.ImportPath, proj.prefix):
+			gr = group(proj.prefix)
+		default:
+			d, err := r.resolve(pkg.ImportPath)
+			if err != nil {
+				return nil, err
+			}
+			gr = group(d.Prefix)
+			kind, arg := d.Revision()
+			gr.Revision = kind + "=" + arg
+			gr.RequiredBy = d.RequiredBy
+		}
+		gr.Packages = append(gr.Packages, pkg.ImportPath)
+
+		n := &graphNode{ImportPath: pkg.ImportPath, Group: gr.Name}
+		for _, i := range pkg.Imports {
+			if i.Standard && !graphStd {
+				continue
+			}
+			n.Imports = append(n.Imports, i.ImportPath)
+		}
+		sort.Strings(n.Imports)
+		g.Packages = append(g.Packages, n)
+	}
+	return &g, nil
+}
+
+// writeDot writes g to w in Graphviz DOT format, each group as a cluster.
+func writeDot(w io.Writer, g *importGraph) {
+	fmt.Fprintln(w, "digraph kang {")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	for i, gr := range g.Groups {
+		label := []string{gr.Name}
+		if gr.Revision != "" {
+			label[0] += " @ " + gr.Revision
+		}
+		if gr.RequiredBy != "" {
+			label = append(label, "required by "+gr.RequiredBy)
+		}
+		fmt.Fprintf(w, "\tsubgraph cluster_%d {\n", i)
+		fmt.Fprintf(w, "\t\tlabel=%s;\n", dotLabel(label...))
+		for _, p := range gr.Packages {
+			fmt.Fprintf(w, "\t\t%q;\n", p)
+		}
+		fmt.Fprintln(w, "\t}")
+	}
+	for _, n := range g.Packages {
+		for _, i := range n.Imports {
+			fmt.Fprintf(w, "\t%q -> %q;\n", n.ImportPath, i)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// dotLabel returns a quoted DOT string holding lines.
+func dotLabel(lines ...string) string {
+	for i, l := range lines {
+		q := strconv.Quote(l)
+		lines[i] = q[1 : len(q)-1]
+	}
+	return `"` + strings.Join(lines, `\n`) + `"`
+}
+
+// printWhy writes to w the shortest chain of imports from one of roots
+// to the package target, or to a package below target.
+func printWhy(w io.Writer, roots []*kang.Package, target string) error {
+	prev := make(map[*kang.Package]*kang.Package)
+	seen := make(map[*kang.Package]bool)
+	queue := append([]*kang.Package(nil), roots...)
+	for _, root := range roots {
+		seen[root] = true
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if hasPathPrefix(pkg.ImportPath, target) {
+			var chain []string
+			for p := pkg; p != nil; p = prev[p] {
+				chain = append([]string{p.ImportPath}, chain...)
+			}
+			fmt.Fprintf(w, "# %s\n%s\n", target, strings.Join(chain, "\n"))
+			return nil
+		}
+		for _, i := range pkg.Imports {
+			if !seen[i] {
+				seen[i] = true
+				prev[i] = pkg
+				queue = append(queue, i)
+			}
+		}
+	}
+	fmt.Fprintf(w, "# %s\n(%s is not imported by the named packages)\n", target, target)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var InstallCmd = &Command{
+	Name:      "install",
+	UsageLine: "install [build flags] [-os goos] [-arch goarch] [packages]",
+	Short:     "build the packages in the project and install the commands",
+	Long: `
+Install builds the named packages, by default every package in the
+project, like build, but links commands into a bin directory rather
+than the project root.
+
+The bin directory is, in order of preference, the bindir= setting on
+the project line of the .kangfile, relative to the project root, then
+$GOBIN, then $HOME/bin.
+
+    project prefix=github.com/constabulary/kang bindir=bin
+
+Install accepts the same flags and package names as build, see
+'kang help build'.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		addBuildFlags(fs)
+		addTargetFlags(fs)
+	},
+	Run: runInstall,
+}
+
+func init() {
+	registerCommand(InstallCmd)
+}
+
+func runInstall(args []string) error {
+	proj := openProject()
+	ctx := newContext(proj)
+	bindir, err := installDir(proj)
+	if err != nil {
+		return err
+	}
+	ctx.Bindir = bindir
+
+	pkgs, lock, err := loadPackages(proj, ctx, args)
+	if err != nil {
+		return err
+	}
+	if err := lock.write(); err != nil {
+		return err
+	}
+	return buildAll(pkgs...)
+}
+
+// installDir returns the directory kang install links commands into.
+func installDir(proj *project) (string, error) {
+	if dir := proj.kf.Project.Bindir; dir != "" {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(proj.rootdir, filepath.FromSlash(dir))
+		}
+		return dir, nil
+	}
+	if dir := os.Getenv("GOBIN"); dir != "" {
+		return dir, nil
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, "bin"), nil
+	}
+	return "", fmt.Errorf("cannot determine install directory: set bindir= in the .kangfile, or $GOBIN")
+}
@@ -1,28 +1,86 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 )
 
 // kangfile loads a file of tagged key value pairs.
 
-// ParseFile parses path into a tagged key value map.
+// Kangfile describes the contents of a .kangfile.
+type Kangfile struct {
+	Project      Project
+	Dependencies []Dependency // in the order they appear in the file
+}
+
+// Project holds the settings on the project line of a .kangfile.
+type Project struct {
+	Prefix string // import path prefix of the project
+	Bindir string // bindir=DIR, optional, where kang install puts commands
+	Line   int    // line number of the project line, 0 if absent
+}
+
+// Dependency describes a dependency declared in a .kangfile.
+// Exactly one of Version, Tag or Commit is set.
+type Dependency struct {
+	Prefix  string // import path prefix of the dependency
+	Version string // version=SEMVER
+	Tag     string // tag=TAG
+	Commit  string // commit=SHA1
+	Repo    string // repo=URL, optional
+	Line    int    // line number of the declaration
+
+	// RequiredBy names the dependency whose .kangfile declared this
+	// dependency, blank if it was declared by the project's .kangfile.
+	RequiredBy string
+}
+
+// Revision returns the kind, one of version, tag or commit, and the
+// value of the revision requested by d.
+func (d *Dependency) Revision() (kind, arg string) {
+	switch {
+	case d.Version != "":
+		return "version", d.Version
+	case d.Tag != "":
+		return "tag", d.Tag
+	default:
+		return "commit", d.Commit
+	}
+}
+
+// String returns the declaration of d as it appears in a .kangfile.
+func (d *Dependency) String() string {
+	kind, arg := d.Revision()
+	return d.Prefix + " " + kind + "=" + arg
+}
+
+// declaredAt describes where d was declared.
+func (d *Dependency) declaredAt() string {
+	if d.RequiredBy != "" {
+		return "required by " + d.RequiredBy
+	}
+	return fmt.Sprintf("line %d of the .kangfile", d.Line)
+}
+
+// ParseFile parses the .kangfile at path.
 // See Parse for the syntax of the file.
-func ParseFile(path string) (map[string]map[string]string, error) {
+func ParseFile(path string) (*Kangfile, error) {
 	r, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer r.Close()
-	return Parse(r)
+	kf, err := Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return kf, nil
 }
 
-// Parse parses the contents of r into a tagged key value map.
-// If successful Parse returns a map[string]map[string]string.
+// Parse parses the contents of r into a Kangfile.
 // The format of the line is
 //
 //     name key=value [key=value]...
@@ -38,36 +96,104 @@ func ParseFile(path string) (map[string]map[string]string, error) {
 //     // third kind of comment
 //       lines starting with blank lines are also ignored
 //     github.com/pkg/sftp version=0.2.1
-func Parse(r io.Reader) (map[string]map[string]string, error) {
-	sc := bufio.NewScanner(r)
-	m := make(map[string]map[string]string)
-	var lineno int
-	for sc.Scan() {
-		line := sc.Text()
-		lineno++
-
-		// skip blank line
-		if line == "" {
+//
+// The line named project holds the settings of the project, every other
+// line declares a dependency.
+func Parse(r io.Reader) (*Kangfile, error) {
+	f, err := ParseSyntax(r)
+	if err != nil {
+		return nil, err
+	}
+	return f.Kangfile()
+}
+
+// Kangfile validates the declarations in f and returns the Kangfile
+// they describe.
+func (f *File) Kangfile() (*Kangfile, error) {
+	var kf Kangfile
+	seen := make(map[string]int) // line of each declaration
+	for i, l := range f.Lines {
+		lineno := i + 1
+		if l.Name == "" {
+			// commentary
 			continue
 		}
 
-		// valid lines start with a letter or number everything else is ignored.
-		// we don't need to worry about unicode because import paths are restricted
-		// to the DNS character set, which is a subset of ASCII.
-		if !isLetterOrNumber(line[0]) {
-			continue
+		if prev, ok := seen[l.Name]; ok {
+			return nil, fmt.Errorf("%d: %s: duplicate declaration, previously declared on line %d", lineno, l.Name, prev)
 		}
+		seen[l.Name] = lineno
 
-		name, kv, err := parseLine(line)
+		var err error
+		kv := l.keyvals()
+		if l.Name == "project" {
+			kf.Project, err = parseProject(kv, lineno)
+		} else {
+			var d Dependency
+			d, err = parseDependency(l.Name, kv, lineno)
+			kf.Dependencies = append(kf.Dependencies, d)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("%d: %v", lineno, err)
+			return nil, fmt.Errorf("%d: %s: %v", lineno, l.Name, err)
 		}
-		m[name] = kv
 	}
-	return m, sc.Err()
+	return &kf, nil
+}
+
+func parseProject(kv map[string]string, lineno int) (Project, error) {
+	p := Project{Line: lineno}
+	for _, key := range sortedKeys(kv) {
+		switch key {
+		case "prefix":
+			p.Prefix = kv[key]
+		case "bindir":
+			p.Bindir = kv[key]
+		default:
+			return p, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return p, nil
+}
+
+func parseDependency(name string, kv map[string]string, lineno int) (Dependency, error) {
+	d := Dependency{Prefix: name, Line: lineno}
+	var revs []string
+	for _, key := range sortedKeys(kv) {
+		switch key {
+		case "version":
+			d.Version = kv[key]
+		case "tag":
+			d.Tag = kv[key]
+		case "commit":
+			d.Commit = kv[key]
+		case "repo":
+			d.Repo = kv[key]
+			continue
+		default:
+			return d, fmt.Errorf("unknown key %q", key)
+		}
+		revs = append(revs, key+"="+kv[key])
+	}
+	switch len(revs) {
+	case 0:
+		return d, fmt.Errorf("expected one of version=, tag= or commit=")
+	case 1:
+		return d, nil
+	default:
+		return d, fmt.Errorf("only one of version=, tag= or commit= may be given, got %s", strings.Join(revs, " "))
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
-func parseLine(line string) (string, map[string]string, error) {
+func parseLine(line string) (string, []KeyVal, error) {
 	args := splitLine(line)
 	name, rest := args[0], args[1:]
 	if len(rest) == 0 {
@@ -81,7 +207,8 @@ func parseLine(line string) (string, map[string]string, error) {
 	return name, kv, nil
 }
 
-func parseKeyVal(args []string) (map[string]string, error) {
+func parseKeyVal(args []string) ([]KeyVal, error) {
+	var kvs []KeyVal
 	m := make(map[string]string)
 	for _, kv := range args {
 		if strings.HasPrefix(kv, "=") {
@@ -98,11 +225,12 @@ func parseKeyVal(args []string) (map[string]string, error) {
 				return nil, fmt.Errorf("duplicate key=value pair, have \"%s=%s\" got %q", key, v, kv)
 			}
 			m[key] = args[1]
+			kvs = append(kvs, KeyVal{Key: key, Value: args[1]})
 		default:
 			return nil, fmt.Errorf("expected key=value pair, got %q", kv)
 		}
 	}
-	return m, nil
+	return kvs, nil
 }
 
 func isLetterOrNumber(r byte) bool {
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File is the syntax tree of a .kangfile. Unlike Kangfile, File retains
+// the comments, blank lines and ordering of the original file, so it
+// can be edited and written back without disturbing the commentary.
+type File struct {
+	Lines []*Line
+}
+
+// Line is a single line of a .kangfile. Lines which declare the project
+// or a dependency have a Name and key=value Args, every other line is
+// commentary and is kept verbatim in Text.
+type Line struct {
+	Name string
+	Args []KeyVal // in the order they were written
+	Text string   // original text of a commentary line
+}
+
+// KeyVal is a single key=value pair on a declaration line.
+type KeyVal struct {
+	Key, Value string
+}
+
+// ParseSyntax parses the contents of r into a File.
+// See Parse for the syntax of the file.
+func ParseSyntax(r io.Reader) (*File, error) {
+	sc := bufio.NewScanner(r)
+	var f File
+	var lineno int
+	for sc.Scan() {
+		line := sc.Text()
+		lineno++
+
+		// valid lines start with a letter or number everything else is commentary.
+		// we don't need to worry about unicode because import paths are restricted
+		// to the DNS character set, which is a subset of ASCII.
+		if line == "" || !isLetterOrNumber(line[0]) {
+			f.Lines = append(f.Lines, &Line{Text: line})
+			continue
+		}
+
+		name, kv, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %v", lineno, err)
+		}
+		f.Lines = append(f.Lines, &Line{Name: name, Args: kv})
+	}
+	return &f, sc.Err()
+}
+
+// keyvals returns the Args of l as a map.
+func (l *Line) keyvals() map[string]string {
+	m := make(map[string]string)
+	for _, kv := range l.Args {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+// set sets key to value, preserving the position of key if present.
+func (l *Line) set(key, value string) {
+	for i := range l.Args {
+		if l.Args[i].Key == key {
+			l.Args[i].Value = value
+			return
+		}
+	}
+	l.Args = append(l.Args, KeyVal{Key: key, Value: value})
+}
+
+// setRevision replaces the version, tag or commit of a dependency line
+// with kind=arg, keeping the position of the revision it replaces.
+func (l *Line) setRevision(kind, arg string) {
+	var args []KeyVal
+	var done bool
+	for _, kv := range l.Args {
+		switch kv.Key {
+		case "version", "tag", "commit":
+			if !done {
+				args = append(args, KeyVal{Key: kind, Value: arg})
+				done = true
+			}
+			continue
+		}
+		args = append(args, kv)
+	}
+	if !done {
+		args = append([]KeyVal{{Key: kind, Value: arg}}, args...)
+	}
+	l.Args = args
+}
+
+// Lookup returns the declaration line for name, or nil if there is none.
+func (f *File) Lookup(name string) *Line {
+	for _, l := range f.Lines {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+// SetDependency adds d to the file, or if a dependency with the same
+// prefix is already declared, updates its revision, and its repository
+// if d.Repo is set, in place. New dependencies are added after the last
+// declaration.
+func (f *File) SetDependency(d Dependency) {
+	l := f.Lookup(d.Prefix)
+	if l == nil {
+		l = &Line{Name: d.Prefix}
+		last := len(f.Lines)
+		for last > 0 && f.Lines[last-1].Name == "" {
+			last--
+		}
+		f.Lines = append(f.Lines[:last], append([]*Line{l}, f.Lines[last:]...)...)
+	}
+
+	kind, arg := d.Revision()
+	l.setRevision(kind, arg)
+	if d.Repo != "" {
+		l.set("repo", d.Repo)
+	}
+}
+
+// RemoveDependency removes the declaration of the dependency with the
+// given prefix. It reports whether the dependency was declared.
+func (f *File) RemoveDependency(prefix string) bool {
+	for i, l := range f.Lines {
+		if l.Name == prefix && prefix != "project" {
+			f.Lines = append(f.Lines[:i], f.Lines[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Format returns the contents of f in canonical form. Commentary lines
+// are written unchanged. Within each run of consecutive declarations
+// the name and key=value columns are aligned.
+func (f *File) Format() []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(f.Lines); {
+		if f.Lines[i].Name == "" {
+			buf.WriteString(f.Lines[i].Text)
+			buf.WriteByte('\n')
+			i++
+			continue
+		}
+
+		// find the block of declarations starting at i.
+		j := i
+		for j < len(f.Lines) && f.Lines[j].Name != "" {
+			j++
+		}
+		block := f.Lines[i:j]
+
+		var widths []int
+		for _, l := range block {
+			for c, col := range l.columns() {
+				if c == len(widths) {
+					widths = append(widths, 0)
+				}
+				if len(col) > widths[c] {
+					widths[c] = len(col)
+				}
+			}
+		}
+		for _, l := range block {
+			cols := l.columns()
+			for c, col := range cols {
+				buf.WriteString(col)
+				if c < len(cols)-1 {
+					buf.WriteString(strings.Repeat(" ", widths[c]-len(col)+1))
+				}
+			}
+			buf.WriteByte('\n')
+		}
+		i = j
+	}
+	return buf.Bytes()
+}
+
+// columns returns the name and key=value pairs of l.
+func (l *Line) columns() []string {
+	cols := []string{l.Name}
+	for _, kv := range l.Args {
+		cols = append(cols, kv.Key+"="+kv.Value)
+	}
+	return cols
+}
+
+// WriteFile writes the formatted contents of f to path. The contents are
+// written to a temporary file in the same directory, then renamed over
+// path, so readers never observe a partially written file. The file
+// keeps the mode of the file it replaces, or is created 0644.
+func (f *File) WriteFile(path string) error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".kangfile")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(f.Format()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ParseSyntaxFile parses the .kangfile at path into a File.
+func ParseSyntaxFile(path string) (*File, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	f, err := ParseSyntax(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	return f, nil
+}
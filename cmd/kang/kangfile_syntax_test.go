@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		src  string
+		edit func(*testing.T, *File) // applied before formatting, if not nil
+		want string
+	}{{
+		// canonical files are unchanged.
+		src:  "project prefix=example.com/p\n",
+		want: "project prefix=example.com/p\n",
+	}, {
+		// columns are aligned within a block of declarations.
+		src:  "project prefix=example.com/p\ngithub.com/pkg/errors version=0.8.0\nexample.com/a\ttag=v1.0.0  repo=https://example.com/a.git\n",
+		want: "project               prefix=example.com/p\ngithub.com/pkg/errors version=0.8.0\nexample.com/a         tag=v1.0.0           repo=https://example.com/a.git\n",
+	}, {
+		// commentary is kept verbatim and separates blocks.
+		src:  "# deps\nproject prefix=example.com/p\n\n; other\n  indented comment\nexample.com/a    version=1.0.0\n// last\n",
+		want: "# deps\nproject prefix=example.com/p\n\n; other\n  indented comment\nexample.com/a version=1.0.0\n// last\n",
+	}, {
+		// key order is preserved.
+		src:  "example.com/a repo=https://example.com/a.git commit=0123abc\n",
+		want: "example.com/a repo=https://example.com/a.git commit=0123abc\n",
+	}, {
+		// a missing final newline is added.
+		src:  "project prefix=example.com/p",
+		want: "project prefix=example.com/p\n",
+	}, {
+		// new dependencies go after the last declaration, before
+		// trailing commentary, and are aligned with their block.
+		src: "project prefix=example.com/p\nexample.com/a version=1.0.0\n\n# trailing\n",
+		edit: func(t *testing.T, f *File) {
+			f.SetDependency(Dependency{Prefix: "example.com/longer", Tag: "v1"})
+		},
+		want: "project            prefix=example.com/p\nexample.com/a      version=1.0.0\nexample.com/longer tag=v1\n\n# trailing\n",
+	}, {
+		// a declared dependency is updated in place, keeping the
+		// position of its revision and repository.
+		src: "# deps\nproject prefix=example.com/p\nexample.com/a repo=https://example.com/a.git version=1.0.0\nexample.com/b commit=0123abc\n",
+		edit: func(t *testing.T, f *File) {
+			f.SetDependency(Dependency{Prefix: "example.com/a", Tag: "v2.0.0", Repo: "https://mirror.example.com/a.git"})
+		},
+		want: "# deps\nproject       prefix=example.com/p\nexample.com/a repo=https://mirror.example.com/a.git tag=v2.0.0\nexample.com/b commit=0123abc\n",
+	}, {
+		src: "project prefix=example.com/p\n# a\nexample.com/a version=1.0.0\n# b\nexample.com/b version=2.0.0\n",
+		edit: func(t *testing.T, f *File) {
+			if !f.RemoveDependency("example.com/a") {
+				t.Errorf("RemoveDependency(example.com/a): got false, want true")
+			}
+			for _, prefix := range []string{"example.com/c", "project"} {
+				if f.RemoveDependency(prefix) {
+					t.Errorf("RemoveDependency(%s): got true, want false", prefix)
+				}
+			}
+		},
+		want: "project prefix=example.com/p\n# a\n# b\nexample.com/b version=2.0.0\n",
+	}}
+
+	for _, tt := range tests {
+		f, err := ParseSyntax(strings.NewReader(tt.src))
+		if err != nil {
+			t.Errorf("ParseSyntax(%q): %v", tt.src, err)
+			continue
+		}
+		if tt.edit != nil {
+			tt.edit(t, f)
+		}
+		got := string(f.Format())
+		if got != tt.want {
+			t.Errorf("Format(%q):\ngot  %q\nwant %q", tt.src, got, tt.want)
+			continue
+		}
+
+		// formatting is idempotent.
+		f, err = ParseSyntax(strings.NewReader(got))
+		if err != nil {
+			t.Errorf("ParseSyntax(%q): %v", got, err)
+			continue
+		}
+		if again := string(f.Format()); again != got {
+			t.Errorf("Format(%q):\ngot  %q\nwant %q", got, again, got)
+		}
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	tests := []struct {
+		src, err string
+	}{
+		{"# ok\nproject\n", "2: project: expected key=value pair after name"},
+		{"example.com/a version\n", `1: example.com/a: expected key=value pair, got "version"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseSyntax(strings.NewReader(tt.src))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("ParseSyntax(%q): got error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestWriteFileMode(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	f := &File{Lines: []*Line{{Name: "project", Args: []KeyVal{{Key: "prefix", Value: "example.com/p"}}}}}
+
+	tests := []struct {
+		existing os.FileMode // mode of the file replaced, 0 if none
+		want     os.FileMode
+	}{
+		{0, 0644},
+		{0600, 0600},
+		{0664, 0664},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf(".kangfile%d", i))
+		if tt.existing != 0 {
+			if err := ioutil.WriteFile(path, nil, tt.existing); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(path, tt.existing); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.WriteFile(path); err != nil {
+			t.Errorf("WriteFile(%s): %v", path, err)
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Errorf("WriteFile(%s): %v", path, err)
+			continue
+		}
+		if got := fi.Mode().Perm(); got != tt.want {
+			t.Errorf("WriteFile(%s) over mode %v: got mode %v, want %v", path, tt.existing, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want *Kangfile
+		err  string // substring of the expected error
+	}{{
+		src: "project prefix=example.com/p\n",
+		want: &Kangfile{
+			Project: Project{Prefix: "example.com/p", Line: 1},
+		},
+	}, {
+		src: "# comment\nproject prefix=example.com/p bindir=bin\n\ngithub.com/pkg/errors version=0.8.0\n",
+		want: &Kangfile{
+			Project: Project{Prefix: "example.com/p", Bindir: "bin", Line: 2},
+			Dependencies: []Dependency{
+				{Prefix: "github.com/pkg/errors", Version: "0.8.0", Line: 4},
+			},
+		},
+	}, {
+		src: "project prefix=example.com/p\nexample.com/a\ttag=v1.0.0   repo=https://example.com/a.git\nexample.com/b commit=0123abc\n",
+		want: &Kangfile{
+			Project: Project{Prefix: "example.com/p", Line: 1},
+			Dependencies: []Dependency{
+				{Prefix: "example.com/a", Tag: "v1.0.0", Repo: "https://example.com/a.git", Line: 2},
+				{Prefix: "example.com/b", Commit: "0123abc", Line: 3},
+			},
+		},
+	}, {
+		src: "project\n",
+		err: "1: project: expected key=value pair after name",
+	}, {
+		src: "project prefix=\n",
+		err: `1: project: expected key=value pair, missing value "prefix="`,
+	}, {
+		src: "project =example.com/p\n",
+		err: `1: project: expected key=value pair, missing key "=example.com/p"`,
+	}, {
+		src: "project prefix=a=b\n",
+		err: `1: project: expected key=value pair, got "prefix=a=b"`,
+	}, {
+		src: "project prefix=a prefix=b\n",
+		err: `1: project: duplicate key=value pair, have "prefix=a" got "prefix=b"`,
+	}, {
+		src: "project prefix=example.com/p name=p\n",
+		err: `1: project: unknown key "name"`,
+	}, {
+		src: "project prefix=example.com/p\nexample.com/a repo=https://example.com/a.git\n",
+		err: "2: example.com/a: expected one of version=, tag= or commit=",
+	}, {
+		src: "project prefix=example.com/p\nexample.com/a version=1.0.0 tag=v1.0.0\n",
+		err: "2: example.com/a: only one of version=, tag= or commit= may be given, got tag=v1.0.0 version=1.0.0",
+	}, {
+		src: "project prefix=example.com/p\nexample.com/a branch=master\n",
+		err: `2: example.com/a: unknown key "branch"`,
+	}, {
+		src: "project prefix=example.com/p\nexample.com/a version=1.0.0\n\nexample.com/a version=1.1.0\n",
+		err: "4: example.com/a: duplicate declaration, previously declared on line 2",
+	}, {
+		src: "project prefix=example.com/p\nproject prefix=example.com/q\n",
+		err: "2: project: duplicate declaration, previously declared on line 1",
+	}}
+
+	for _, tt := range tests {
+		got, err := Parse(strings.NewReader(tt.src))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse(%q): got error %v, want %q", tt.src, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q): got %+v, want %+v", tt.src, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"os"
+	"sort"
+	"text/template"
+
+	"github.com/constabulary/kang"
+)
+
+var (
+	listJSON   bool   // -json
+	listFormat string // -f
+	listDeps   bool   // -deps
+)
+
+var ListCmd = &Command{
+	Name:      "list",
+	UsageLine: "list [-json] [-f format] [-deps] [-race] [-gcflags flags] [-ldflags flags] [-tags tags] [-os goos] [-arch goarch] [packages]",
+	Short:     "list packages",
+	Long: `
+List lists the named packages, by default every package in the project,
+one per line. Packages are named as for build, see 'kang help build'.
+
+The -json flag prints each package as a JSON object, in the form printed
+by 'go list -json', so tools which understand the go command's output
+can be used with kang projects. The fields are
+
+    type Package struct {
+        Dir        string // directory containing package sources
+        ImportPath string // import path of package
+        Name       string // package name
+        Target     string // install path, the binary of a command
+        Archive    string // compiled archive of the package
+        Goroot     bool   // is this package in the Go root?
+        Standard   bool   // is this package part of the standard library?
+        Stale      bool   // would 'kang build' do anything for this package?
+
+        GoFiles  []string // .go source files, excluding CgoFiles
+        CgoFiles []string // .go source files that import "C"
+        CFiles   []string // .c source files
+        HFiles   []string // .h source files
+        SFiles   []string // .s source files
+
+        CgoCFLAGS    []string // cgo: flags for C compiler
+        CgoCPPFLAGS  []string // cgo: flags for C preprocessor
+        CgoLDFLAGS   []string // cgo: flags for linker
+        CgoPkgConfig []string // cgo: pkg-config names
+
+        Imports   []string          // import paths used by this package
+        ImportMap map[string]string // map from source import to ImportPath
+        Deps      []string          // all (recursively) imported dependencies
+    }
+
+The -f flag formats each package with the text/template format, which
+is applied to the structure above. It defaults to {{.ImportPath}}.
+
+The -deps flag also lists the dependencies of the named packages, each
+package is listed after its dependencies.
+
+The -race, -gcflags, -ldflags, -tags, -os and -arch flags are those of
+build, they select the source files, archives and staleness reported.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&listJSON, "json", false, "print packages in JSON format")
+		fs.StringVar(&listFormat, "f", "{{.ImportPath}}", "format packages with the `template`")
+		fs.BoolVar(&listDeps, "deps", false, "also list the dependencies of the named packages")
+		addContextFlags(fs)
+		addTargetFlags(fs)
+	},
+	Run: runList,
+}
+
+func init() {
+	registerCommand(ListCmd)
+}
+
+// listPackage is the form of a package printed by kang list. Its field
+// names and encoding follow those of go list -json.
+type listPackage struct {
+	Dir        string `json:",omitempty"`
+	ImportPath string `json:",omitempty"`
+	Name       string `json:",omitempty"`
+	Target     string `json:",omitempty"`
+	Archive    string `json:",omitempty"`
+	Goroot     bool   `json:",omitempty"`
+	Standard   bool   `json:",omitempty"`
+	Stale      bool   `json:",omitempty"`
+
+	GoFiles  []string `json:",omitempty"`
+	CgoFiles []string `json:",omitempty"`
+	CFiles   []string `json:",omitempty"`
+	HFiles   []string `json:",omitempty"`
+	SFiles   []string `json:",omitempty"`
+
+	CgoCFLAGS    []string `json:",omitempty"`
+	CgoCPPFLAGS  []string `json:",omitempty"`
+	CgoLDFLAGS   []string `json:",omitempty"`
+	CgoPkgConfig []string `json:",omitempty"`
+
+	Imports   []string          `json:",omitempty"`
+	ImportMap map[string]string `json:",omitempty"`
+	Deps      []string          `json:",omitempty"`
+}
+
+func runList(args []string) error {
+	tmpl, err := template.New("list").Parse(listFormat)
+	if err != nil {
+		return err
+	}
+
+	proj := openProject()
+	ctx := newContext(proj)
+	pkgs, _, err := loadPackages(proj, ctx, args)
+	if err != nil {
+		return err
+	}
+	computeStale(pkgs...)
+
+	if listDeps {
+		pkgs = withDeps(pkgs)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, pkg := range pkgs {
+		p := newListPackage(pkg)
+		if listJSON {
+			buf, err := json.MarshalIndent(p, "", "\t")
+			if err != nil {
+				return err
+			}
+			w.Write(buf)
+			w.WriteString("\n")
+			continue
+		}
+		if err := tmpl.Execute(w, p); err != nil {
+			return err
+		}
+		w.WriteString("\n")
+	}
+	return nil
+}
+
+func newListPackage(pkg *kang.Package) *listPackage {
+	p := listPackage{
+		Dir:          pkg.Dir,
+		ImportPath:   pkg.ImportPath,
+		Name:         pkg.Name,
+		Archive:      pkg.Objfile(),
+		Goroot:       pkg.Standard,
+		Standard:     pkg.Standard,
+		Stale:        !pkg.NotStale,
+		GoFiles:      pkg.GoFiles,
+		CgoFiles:     pkg.CgoFiles,
+		CFiles:       pkg.CFiles,
+		HFiles:       pkg.HFiles,
+		SFiles:       pkg.SFiles,
+		CgoCFLAGS:    pkg.CgoCFLAGS,
+		CgoCPPFLAGS:  pkg.CgoCPPFLAGS,
+		CgoLDFLAGS:   pkg.CgoLDFLAGS,
+		CgoPkgConfig: pkg.CgoPkgConfig,
+		ImportMap:    pkg.ImportMap,
+	}
+	p.Target = p.Archive
+	if pkg.Main {
+		p.Target = pkg.Binfile()
+	}
+
+	imports := make(map[string]bool)
+	for _, i := range pkg.Imports {
+		imports[i.ImportPath] = true
+	}
+	p.Imports = sortedSet(imports)
+
+	deps := make(map[string]bool)
+	for _, d := range withDeps(pkg.Imports) {
+		deps[d.ImportPath] = true
+	}
+	p.Deps = sortedSet(deps)
+	return &p
+}
+
+// withDeps returns pkgs and their dependencies, each package
+// appearing after its dependencies.
+func withDeps(pkgs []*kang.Package) []*kang.Package {
+	var all []*kang.Package
+	seen := make(map[*kang.Package]bool)
+	var walk func(*kang.Package)
+	walk = func(pkg *kang.Package) {
+		if seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		for _, i := range pkg.Imports {
+			walk(i)
+		}
+		all = append(all, pkg)
+	}
+	for _, pkg := range pkgs {
+		walk(pkg)
+	}
+	return all
+}
+
+func sortedSet(m map[string]bool) []string {
+	var s []string
+	for k := range m {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
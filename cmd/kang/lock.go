@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lockFile is the name of the file, alongside the .kangfile, which records
+// the source each dependency resolved to.
+const lockFile = ".kangfile.lock"
+
+// lockEntry records the source a dependency resolved to.
+type lockEntry struct {
+	Prefix    string
+	Kind, Arg string // revision requested by the .kangfile
+	Commit    string // commit the revision resolved to
+	Hash      string // hash of the source tree, see hashTree
+}
+
+// line returns e as a .kangfile.lock line.
+func (e *lockEntry) line() *Line {
+	return &Line{
+		Name: e.Prefix,
+		Args: []KeyVal{
+			{Key: e.Kind, Value: e.Arg},
+			{Key: "resolved", Value: e.Commit},
+			{Key: "hash", Value: e.Hash},
+		},
+	}
+}
+
+func (e *lockEntry) String() string {
+	return strings.Join(e.line().columns(), " ")
+}
+
+// readLock reads the lock file at path. The lock file uses the syntax of
+// the .kangfile, each line records a dependency as
+//
+//	prefix kind=arg resolved=COMMIT hash=HASH
+func readLock(path string) (map[string]lockEntry, error) {
+	f, err := ParseSyntaxFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]lockEntry)
+	for i, l := range f.Lines {
+		if l.Name == "" {
+			// commentary
+			continue
+		}
+		if len(l.Args) != 3 || l.Args[1].Key != "resolved" || l.Args[2].Key != "hash" {
+			return nil, fmt.Errorf("%s:%d: %s: expected kind=arg resolved=COMMIT hash=HASH", path, i+1, l.Name)
+		}
+		entries[l.Name] = lockEntry{
+			Prefix: l.Name,
+			Kind:   l.Args[0].Key,
+			Arg:    l.Args[0].Value,
+			Commit: l.Args[1].Value,
+			Hash:   l.Args[2].Value,
+		}
+	}
+	return entries, nil
+}
+
+// writeLock writes entries to the lock file at path, sorted by prefix.
+func writeLock(path string, entries map[string]lockEntry) error {
+	f := File{Lines: []*Line{
+		{Text: "# This file is generated by kang, do not edit."},
+		{Text: "# It records the commit and source hash of each dependency."},
+	}}
+	var prefixes []string
+	for prefix := range entries {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		e := entries[prefix]
+		f.Lines = append(f.Lines, e.line())
+	}
+	return f.WriteFile(path)
+}
+
+// A lock holds the lock file entries of the dependencies of a project.
+type lock struct {
+	path    string               // path of the lock file
+	locked  map[string]lockEntry // entries read from the lock file
+	current map[string]lockEntry // entries of the dependencies in the cache
+}
+
+// verifyLock verifies the cache entries of deps against the lock file in
+// rootdir. A dependency whose revision is unchanged since it was locked
+// must resolve to the same commit and source tree; any that do not are
+// reported as a diff between the lock file and the cache. The returned
+// lock is written, once the project has loaded, by its write method.
+func verifyLock(rootdir string, deps []Dependency) (*lock, error) {
+	l := lock{
+		path:    filepath.Join(rootdir, lockFile),
+		current: make(map[string]lockEntry),
+	}
+	var err error
+	l.locked, err = readLock(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var diff []string
+	for _, d := range deps {
+		kind, arg := d.Revision()
+		dir := cacheDir(rootdir, d.Prefix+kind+"="+arg)
+		commit, err := readCommit(dir)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := hashTree(filepath.Join(dir, filepath.FromSlash(d.Prefix)))
+		if err != nil {
+			return nil, err
+		}
+		e := lockEntry{
+			Prefix: d.Prefix,
+			Kind:   kind,
+			Arg:    arg,
+			Commit: commit,
+			Hash:   hash,
+		}
+		l.current[d.Prefix] = e
+
+		locked, ok := l.locked[d.Prefix]
+		if !ok || locked.Kind != e.Kind || locked.Arg != e.Arg {
+			continue
+		}
+		if locked != e {
+			diff = append(diff, "-"+locked.String(), "+"+e.String(), "  cache entry "+dir)
+		}
+	}
+	if len(diff) > 0 {
+		return nil, fmt.Errorf("%s: cached dependencies do not match the lock file:\n%s\nremove the cache entry to fetch the dependency again, or remove its line from %s to accept the change", l.path, strings.Join(diff, "\n"), lockFile)
+	}
+	return &l, nil
+}
+
+// write records the dependencies in the lock file, if it does not already
+// hold them. Dependencies which are new, or whose revision has changed,
+// are recorded, and those no longer required are removed.
+func (l *lock) write() error {
+	if equalLock(l.locked, l.current) {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "writing", lockFile)
+	return writeLock(l.path, l.current)
+}
+
+func equalLock(a, b map[string]lockEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// hashTree returns a hash of the files below dir. The hash is the sha256
+// of a listing of the sha256 and slash separated relative path of each
+// file, in lexical order. Symbolic links are hashed by their target.
+func hashTree(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		var buf []byte
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			buf = []byte(target)
+		} else {
+			buf, err = ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%x  %s\n", sha256.Sum256(buf), filepath.ToSlash(rel))
+		return nil
+	})
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), err
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles writes files, keyed by slash separated path, below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHashTree(t *testing.T) {
+	root := tempDir(t)
+	defer os.RemoveAll(root)
+
+	hash := func(dir string) string {
+		h, err := hashTree(filepath.Join(root, dir))
+		if err != nil {
+			t.Fatalf("hashTree(%s): %v", dir, err)
+		}
+		return h
+	}
+
+	writeFiles(t, filepath.Join(root, "a"), map[string]string{"x.go": "X", "sub/y.go": "Y"})
+	listing := fmt.Sprintf("%x  sub/y.go\n%x  x.go\n", sha256.Sum256([]byte("Y")), sha256.Sum256([]byte("X")))
+	if got, want := hash("a"), fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(listing))); got != want {
+		t.Errorf("hashTree: got %s, want %s", got, want)
+	}
+
+	// the hash depends on the content and relative path of each file,
+	// not on where the tree is.
+	writeFiles(t, filepath.Join(root, "b"), map[string]string{"x.go": "X", "sub/y.go": "Y"})
+	writeFiles(t, filepath.Join(root, "c"), map[string]string{"x.go": "X", "sub/y.go": "Z"})
+	writeFiles(t, filepath.Join(root, "d"), map[string]string{"x.go": "X", "y.go": "Y"})
+	if hash("a") != hash("b") {
+		t.Errorf("hashTree: identical trees hash differently")
+	}
+	if hash("a") == hash("c") {
+		t.Errorf("hashTree: trees with different content hash the same")
+	}
+	if hash("a") == hash("d") {
+		t.Errorf("hashTree: trees with different paths hash the same")
+	}
+
+	// symbolic links are hashed by their target.
+	writeFiles(t, filepath.Join(root, "e"), map[string]string{"x.go": "X"})
+	if err := os.Symlink("x.go", filepath.Join(root, "e", "link.go")); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+	listing = fmt.Sprintf("%x  link.go\n%x  x.go\n", sha256.Sum256([]byte("x.go")), sha256.Sum256([]byte("X")))
+	if got, want := hash("e"), fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(listing))); got != want {
+		t.Errorf("hashTree of symlink: got %s, want %s", got, want)
+	}
+}
+
+func TestVerifyLock(t *testing.T) {
+	rootdir := tempDir(t)
+	defer os.RemoveAll(rootdir)
+
+	// cache creates the cache entry of d, checked out at commit,
+	// holding the source src.
+	cache := func(d Dependency, commit, src string) {
+		kind, arg := d.Revision()
+		dir := cacheDir(rootdir, d.Prefix+kind+"="+arg)
+		writeFiles(t, dir, map[string]string{
+			commitFile:         commit + "\n",
+			d.Prefix + "/a.go": src,
+		})
+	}
+	verify := func(deps ...Dependency) *lock {
+		l, err := verifyLock(rootdir, deps)
+		if err != nil {
+			t.Fatalf("verifyLock(%v): %v", deps, err)
+		}
+		return l
+	}
+	lockText := func() string {
+		buf, err := ioutil.ReadFile(filepath.Join(rootdir, lockFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+
+	v1 := Dependency{Prefix: "example.com/a", Version: "1.0.0"}
+	v2 := Dependency{Prefix: "example.com/a", Version: "2.0.0"}
+	cache(v1, "c1", "package a\n")
+	cache(v2, "c2", "package a // v2\n")
+
+	// verifying does not write the lock file.
+	l := verify(v1)
+	if _, err := os.Stat(filepath.Join(rootdir, lockFile)); !os.IsNotExist(err) {
+		t.Fatalf("verifyLock wrote %s", lockFile)
+	}
+	if err := l.write(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lockText(); !strings.Contains(got, "example.com/a version=1.0.0 resolved=c1 hash=sha256:") {
+		t.Errorf("lock file:\n%s\nwant an entry for example.com/a version=1.0.0", got)
+	}
+
+	// a cache entry whose source no longer matches the lock is reported.
+	cache(v1, "c1", "package a // modified\n")
+	_, err := verifyLock(rootdir, []Dependency{v1})
+	if err == nil {
+		t.Fatalf("verifyLock of modified cache entry: want error")
+	}
+	for _, want := range []string{
+		"cached dependencies do not match the lock file",
+		"\n-example.com/a version=1.0.0 resolved=c1 hash=sha256:",
+		"\n+example.com/a version=1.0.0 resolved=c1 hash=sha256:",
+		"\n  cache entry " + cacheDir(rootdir, "example.com/aversion=1.0.0"),
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("verifyLock of modified cache entry: got error %v, want %q", err, want)
+		}
+	}
+
+	// as is a commit which differs.
+	cache(v1, "c3", "package a\n")
+	if _, err := verifyLock(rootdir, []Dependency{v1}); err == nil || !strings.Contains(err.Error(), "resolved=c3") {
+		t.Errorf("verifyLock of moved revision: got error %v, want a diff with resolved=c3", err)
+	}
+
+	// a dependency whose revision has changed is recorded afresh.
+	l = verify(v2)
+	if err := l.write(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lockText(); !strings.Contains(got, "example.com/a version=2.0.0 resolved=c2") || strings.Contains(got, "version=1.0.0") {
+		t.Errorf("lock file:\n%s\nwant only an entry for example.com/a version=2.0.0", got)
+	}
+
+	// dependencies no longer required are removed.
+	l = verify()
+	if err := l.write(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lockText(); strings.Contains(got, "example.com/a") {
+		t.Errorf("lock file:\n%s\nwant no entry for example.com/a", got)
+	}
+}
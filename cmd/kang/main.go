@@ -7,10 +7,13 @@ import (
 	"go/build"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/constabulary/kang"
 )
@@ -25,62 +28,183 @@ func check(err error) {
 func fatal(arg interface{}, args ...interface{}) {
 	fmt.Fprint(os.Stderr, "fatal: ", arg)
 	fmt.Fprintln(os.Stderr, args...)
-	os.Exit(1)
+	exit(1)
+}
+
+var (
+	exitMu    sync.Mutex
+	exitHooks []func()
+	exitOnce  sync.Once
+)
+
+// atExit registers fn to be called when kang exits, including via fatal,
+// an interrupt, or a panic in the main goroutine. Hooks are called in the
+// reverse order they were registered.
+func atExit(fn func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitHooks = append(exitHooks, fn)
+}
+
+// runExitHooks runs the hooks registered with atExit, once.
+func runExitHooks() {
+	exitOnce.Do(func() {
+		exitMu.Lock()
+		hooks := exitHooks
+		exitMu.Unlock()
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i]()
+		}
+	})
+}
+
+// exit runs the hooks registered with atExit, then exits with code.
+func exit(code int) {
+	runExitHooks()
+	os.Exit(code)
+}
+
+// handleSignals arranges for kang to run its exit hooks and exit when
+// interrupted or terminated.
+func handleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		fmt.Fprintln(os.Stderr, "kang:", sig)
+		exit(1)
+	}()
 }
 
 func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			runExitHooks()
+			panic(r)
+		}
+	}()
+	handleSignals()
+
+	flag.Usage = usage
 	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		usage()
+	}
+
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "kang: unknown command %q\n\n", name)
+		usage()
+	}
+
+	fs := cmd.FlagSet()
+	fs.Parse(args[1:])
+	check(cmd.Run(fs.Args()))
+	exit(0)
+}
+
+// project describes a kang project; the tree rooted at the directory
+// containing the .kangfile.
+type project struct {
+	rootdir string // directory containing the .kangfile
+	prefix  string // import path prefix of the project
+	kf      *Kangfile
+}
+
+// openProject locates the .kangfile governing the current directory
+// and returns the project it describes.
+func openProject() *project {
 	f, err := findkangfile(cwd())
 	check(err)
 
 	f, err = filepath.Abs(f)
 	check(err)
 
-	fmt.Println("Using", f)
+	fmt.Fprintln(os.Stderr, "Using", f)
 
 	kf, err := ParseFile(f)
 	check(err)
 
-	prefix, ok := kf["project"]["prefix"]
-	if prefix == "" || !ok {
+	if kf.Project.Prefix == "" {
 		fatal("project prefix missing from .kangfile")
 	}
 
-	workdir, err := ioutil.TempDir("", "kang")
+	rootdir := filepath.Dir(f)
+	stdlib, err = loadStdlib(rootdir)
 	check(err)
 
-	rootdir := filepath.Dir(f)
-	pkgdir := filepath.Join(rootdir, ".kang", "pkg")
+	return &project{
+		rootdir: rootdir,
+		prefix:  kf.Project.Prefix,
+		kf:      kf,
+	}
+}
 
-	ctx := &kang.Context{
-		GOOS:    runtime.GOOS,
-		GOARCH:  runtime.GOARCH,
-		Workdir: workdir,
-		Pkgdir:  pkgdir,
-		Bindir:  rootdir,
+// newContext returns a kang.Context for building proj. The Context's
+// temporary Workdir is removed when kang exits, unless -work was given.
+func newContext(proj *project) *kang.Context {
+	workdir, err := ioutil.TempDir("", "kang")
+	check(err)
+	if keepWork {
+		fmt.Fprintln(os.Stderr, "WORK="+workdir)
+	} else {
+		atExit(func() { os.RemoveAll(workdir) })
 	}
 
-	action := "build"
+	goos, goarch := targetOS, targetArch
+	if goos == "" {
+		goos = envOr("GOOS", runtime.GOOS)
+	}
+	if goarch == "" {
+		goarch = envOr("GOARCH", runtime.GOARCH)
+	}
 
-	switch action {
-	case "build":
-		srcs := loadSources(prefix, rootdir)
-		for _, src := range srcs {
-			fmt.Printf("loaded %s (%s)\n", src.ImportPath, src.Name)
-		}
+	opts := []func(*kang.Context) error{
+		kang.Gcflags(strings.Fields(buildGcflags)...),
+		kang.Ldflags(strings.Fields(buildLdflags)...),
+		kang.Tags(strings.FieldsFunc(buildTags, func(r rune) bool {
+			return r == ' ' || r == ','
+		})...),
+	}
+	if buildForce {
+		opts = append(opts, kang.Force)
+	}
+	if buildRace {
+		opts = append(opts, kang.WithRace)
+	}
+	ctx, err := kang.NewContext(opts...)
+	check(err)
 
-		srcs = loadDependencies(rootdir, kf, srcs...)
+	ctx.GOOS = goos
+	ctx.GOARCH = goarch
+	ctx.Workdir = workdir
+	ctx.Pkgdir = filepath.Join(proj.rootdir, ".kang", "pkg")
+	ctx.Bindir = proj.rootdir
+	return ctx
+}
 
-		pkgs := transform(ctx, srcs...)
-		computeStale(pkgs...)
+// buildContext returns a go/build.Context which selects source files
+// for the target, and with the build tags, described by ctx.
+func buildContext(ctx *kang.Context) *build.Context {
+	bctx := build.Default
+	bctx.GOOS = ctx.GOOS
+	bctx.GOARCH = ctx.GOARCH
+	bctx.BuildTags = ctx.BuildTags()
+	if ctx.GOOS != runtime.GOOS || ctx.GOARCH != runtime.GOARCH {
+		// go/build only enables cgo for the host by default.
+		bctx.CgoEnabled = os.Getenv("CGO_ENABLED") == "1"
+	}
+	return &bctx
+}
 
-		targets := make(map[string]func() error)
-		fn, err := buildPackages(targets, pkgs...)
-		check(err)
-		check(fn())
-	default:
-		fatal("unknown action:", action)
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return def
 }
 
 func cwd() string {
@@ -92,40 +216,100 @@ func cwd() string {
 // transform takes a slice of go/build.Package and returns the
 // corresponding slice of kang.Packages.
 func transform(ctx *kang.Context, v ...*build.Package) []*kang.Package {
+	bctx := buildContext(ctx)
 	srcs := make(map[string]*build.Package)
 	for _, pkg := range v {
 		srcs[pkg.ImportPath] = pkg
 	}
 
 	var pkgs []*kang.Package
-	seen := make(map[string]bool)
+	seen := make(map[string]*kang.Package)
 
-	var walk func(src *build.Package)
-	walk = func(src *build.Package) {
-		if seen[src.ImportPath] {
-			return
-		}
-		seen[src.ImportPath] = true
+	// stack holds the packages being walked, and the import each
+	// is walking, so an import cycle can be reported in full.
+	var stack []*importFrame
+	walking := make(map[string]bool)
 
-		for _, i := range src.Imports {
-			if stdlib[i] {
-				// skip stdlib package
+	var walk func(src *build.Package) *kang.Package
+	walk = func(src *build.Package) *kang.Package {
+		if pkg, ok := seen[src.ImportPath]; ok {
+			return pkg
+		}
+		pkg := &kang.Package{
+			Context:      ctx,
+			ImportPath:   src.ImportPath,
+			Name:         src.Name,
+			Dir:          src.Dir,
+			GoFiles:      src.GoFiles,
+			CgoFiles:     src.CgoFiles,
+			CFiles:       src.CFiles,
+			HFiles:       src.HFiles,
+			SFiles:       src.SFiles,
+			Standard:     src.Goroot || stdlib[src.ImportPath],
+			Main:         src.Name == "main",
+			CgoCFLAGS:    src.CgoCFLAGS,
+			CgoCPPFLAGS:  src.CgoCPPFLAGS,
+			CgoLDFLAGS:   src.CgoLDFLAGS,
+			CgoPkgConfig: src.CgoPkgConfig,
+		}
+		seen[src.ImportPath] = pkg
+		frame := &importFrame{src: src}
+		stack = append(stack, frame)
+		walking[src.ImportPath] = true
+
+		imported := make(map[string]bool)
+		for _, i := range stringList(src.Imports, cgoImports(src), linkerImports(ctx, src)) {
+			if i == "C" {
+				// skip cgo pseudo package
+				continue
+			}
+			if imported[i] {
+				// implicit imports may also be explicit
 				continue
 			}
-			pkg, ok := srcs[i]
+			imported[i] = true
+			dep, ok := srcs[i]
+			switch {
+			case src.Goroot && !(ok && dep.Goroot):
+				// every import of a standard library package is
+				// itself part of the standard library, resolved
+				// within $GOROOT, so a vendored import finds its
+				// copy in $GOROOT/src/vendor, not the project's.
+				dep = importStdlib(bctx, i, src.Dir)
+				if d, ok := srcs[dep.ImportPath]; ok {
+					dep = d
+				} else {
+					srcs[dep.ImportPath] = dep
+				}
+				ok = true
+			case !ok && stdlib[i]:
+				// standard library packages are loaded on demand.
+				dep = importStdlib(bctx, i, src.Dir)
+				srcs[i] = dep
+				ok = true
+			}
 			if !ok {
 				fatal("transform: pkg ", i, "is not loaded")
 			}
-			walk(pkg)
+			if dep.ImportPath != i {
+				// import resolved via a vendor directory
+				if pkg.ImportMap == nil {
+					pkg.ImportMap = make(map[string]string)
+				}
+				pkg.ImportMap[i] = dep.ImportPath
+			}
+			frame.imp = i
+			check(checkInternal(src, i, dep))
+			if walking[dep.ImportPath] {
+				check(importCycleError(stack, dep.ImportPath))
+			}
+			pkg.Imports = append(pkg.Imports, walk(dep))
 		}
 
-		pkgs = append(pkgs, &kang.Package{
-			Context:    ctx,
-			ImportPath: src.ImportPath,
-			Dir:        src.Dir,
-			GoFiles:    src.GoFiles,
-			Main:       src.Name == "main",
-		})
+		stack = stack[:len(stack)-1]
+		delete(walking, src.ImportPath)
+		pkgs = append(pkgs, pkg)
+		return pkg
 	}
 	for _, p := range v {
 		walk(p)
@@ -133,6 +317,119 @@ func transform(ctx *kang.Context, v ...*build.Package) []*kang.Package {
 	return pkgs
 }
 
+// importFrame records a package being walked by transform and the
+// import it is walking.
+type importFrame struct {
+	src *build.Package
+	imp string // import path, as written in src
+}
+
+// importCycleError returns an error describing the import cycle formed
+// by the frames of stack from the package path to the top of the stack,
+// whose import leads back to path. Each import in the cycle is reported
+// with the position of its import declaration.
+func importCycleError(stack []*importFrame, path string) error {
+	for len(stack) > 0 && stack[0].src.ImportPath != path {
+		stack = stack[1:]
+	}
+	var cycle, imports []string
+	for _, f := range stack {
+		cycle = append(cycle, f.src.ImportPath)
+		pos := f.src.Dir
+		if p := f.src.ImportPos[f.imp]; len(p) > 0 {
+			pos = fmt.Sprintf("%s:%d", p[0].Filename, p[0].Line)
+		}
+		imports = append(imports, fmt.Sprintf("\t%s: %s imports %s", pos, f.src.ImportPath, f.imp))
+	}
+	cycle = append(cycle, path)
+	return fmt.Errorf("import cycle not allowed: %s\n%s", strings.Join(cycle, " -> "), strings.Join(imports, "\n"))
+}
+
+// checkInternal returns an error if src may not import dep, imported as
+// path, because dep is an internal package outside of src's tree. An
+// internal package may only be imported by packages rooted at the parent
+// of its internal directory; those in the standard library, whose internal
+// directory has no parent, only by the standard library.
+func checkInternal(src *build.Package, path string, dep *build.Package) error {
+	parent, ok := internalParent(dep.ImportPath)
+	if !ok {
+		return nil
+	}
+	if parent == "" {
+		if src.Goroot || stdlib[src.ImportPath] {
+			return nil
+		}
+	} else if hasPathPrefix(src.ImportPath, parent) {
+		return nil
+	}
+
+	pos := src.Dir
+	if p := src.ImportPos[path]; len(p) > 0 {
+		pos = fmt.Sprintf("%s:%d", p[0].Filename, p[0].Line)
+	}
+	return fmt.Errorf("%s: use of internal package %s not allowed: %s is not inside %s", pos, dep.ImportPath, src.ImportPath, internalRoot(parent))
+}
+
+// internalParent returns the import path of the directory holding the
+// last internal element of path, and whether path has an internal element.
+func internalParent(path string) (string, bool) {
+	elems := strings.Split(path, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i] == "internal" {
+			return strings.Join(elems[:i], "/"), true
+		}
+	}
+	return "", false
+}
+
+// internalRoot describes the tree which may import internal packages
+// of parent.
+func internalRoot(parent string) string {
+	if parent == "" {
+		return "the standard library"
+	}
+	return parent
+}
+
+// cgoImports returns the packages implicitly imported by the code cgo
+// generates for src.
+func cgoImports(src *build.Package) []string {
+	if len(src.CgoFiles) == 0 {
+		return nil
+	}
+	switch src.ImportPath {
+	case "runtime/cgo", "runtime/race", "runtime/msan":
+		if src.Goroot {
+			// these packages must not depend on runtime/cgo or syscall.
+			return nil
+		}
+	}
+	return []string{"runtime/cgo", "syscall"}
+}
+
+// linkerImports returns the packages implicitly imported by src, if it
+// is a command, as the linker needs them; runtime, which must be built
+// if the standard library is not installed, and, when building with the
+// race detector, runtime/race.
+func linkerImports(ctx *kang.Context, src *build.Package) []string {
+	if src.Name != "main" {
+		return nil
+	}
+	imports := []string{"runtime"}
+	if ctx.Race() {
+		imports = append(imports, "runtime/race")
+	}
+	return imports
+}
+
+// importStdlib loads the standard library package imported as path
+// by a package in srcDir.
+func importStdlib(bctx *build.Context, path, srcDir string) *build.Package {
+	pkg, err := bctx.Import(path, srcDir, 0)
+	check(err)
+	return pkg
+}
+
 // computeStale sets the UpToDate flag on a set of package roots.
 func computeStale(roots ...*kang.Package) {
 	seen := make(map[*kang.Package]bool)
@@ -144,14 +441,18 @@ func computeStale(roots ...*kang.Package) {
 		}
 		seen[pkg] = true
 
+		var stale bool
 		for _, i := range pkg.Imports {
 			if !walk(i) {
-				// a dep is stale so we are stale
-				return false
+				// a dep is stale so we are stale, but keep
+				// going so every dep has its staleness computed.
+				stale = true
 			}
 		}
 
-		stale := pkg.IsStale()
+		if !stale {
+			stale = pkg.IsStale()
+		}
 		pkg.NotStale = !stale
 		return !stale
 	}
@@ -184,75 +485,83 @@ func findkangfile(dir string) (string, error) {
 	}
 }
 
-func buildPackages(targets map[string]func() error, pkgs ...*kang.Package) (func() error, error) {
-	var deps []func() error
+// buildPackages returns an Action which depends on the actions
+// that build each of pkgs.
+func buildPackages(targets map[*kang.Package]*Action, pkgs ...*kang.Package) (*Action, error) {
+	var deps []*Action
 	for _, pkg := range pkgs {
-		fn, err := buildPackage(targets, pkg)
-		check(err)
-		deps = append(deps, fn)
-	}
-	return func() error {
-		for _, fn := range deps {
-			if err := fn(); err != nil {
-				return err
-			}
+		a, err := buildPackage(targets, pkg)
+		if err != nil {
+			return nil, err
 		}
-		return nil
+		deps = append(deps, a)
+	}
+	return &Action{
+		Name: "build",
+		Deps: deps,
 	}, nil
 }
 
-func buildPackage(targets map[string]func() error, pkg *kang.Package) (func() error, error) {
+// buildPackage returns an Action which compiles, and if it is a command,
+// links pkg. The Action depends on the actions which build pkg's imports.
+func buildPackage(targets map[*kang.Package]*Action, pkg *kang.Package) (*Action, error) {
 
 	// if this action is already present in the map, return it
 	// rather than creating a new action.
-	if fn, ok := targets[pkg.ImportPath]; ok {
-		return fn, nil
+	if a, ok := targets[pkg]; ok {
+		return a, nil
 	}
 
 	// step 0. are we stale ?
 	// if this package is not stale, then by definition none of its
 	// dependencies are stale, so ignore this whole tree.
 	if pkg.NotStale {
-		return func() error {
-			fmt.Println(pkg.ImportPath, "is up to date")
-			return nil
-		}, nil
+		a := &Action{
+			Name: pkg.ImportPath,
+			Run: func() error {
+				if !pkg.Standard {
+					fmt.Fprintln(os.Stderr, pkg.ImportPath, "is up to date")
+				}
+				return nil
+			},
+		}
+		targets[pkg] = a
+		return a, nil
 	}
 
 	// step 1. build dependencies
-	var deps []func() error
+	var deps []*Action
 	for _, pkg := range pkg.Imports {
-		fn, err := buildPackage(targets, pkg)
+		a, err := buildPackage(targets, pkg)
 		if err != nil {
 			return nil, err
 		}
-		deps = append(deps, fn)
+		deps = append(deps, a)
 	}
 
 	// step 2. build this package
-	build := func() error {
-		for _, dep := range deps {
-			if err := dep(); err != nil {
+	build := &Action{
+		Name: pkg.ImportPath,
+		Deps: deps,
+		Run: func() error {
+			if err := pkg.Compile(); err != nil {
 				return err
 			}
-		}
-		if err := pkg.Compile(); err != nil {
-			return err
-		}
-		if !pkg.Main {
-			return nil // we're done
-		}
-		return pkg.Link()
+			if !pkg.Main {
+				return nil // we're done
+			}
+			return pkg.Link()
+		},
 	}
 
 	// record the final action as the action that represents
 	// building this package.
-	targets[pkg.ImportPath] = build
+	targets[pkg] = build
 
 	return build, nil
 }
 
-func loadSources(prefix string, dir string) []*build.Package {
+func loadSources(bctx *build.Context, prefix string, dir string) []*build.Package {
 	f, err := os.Open(dir)
 	check(err)
 	files, err := f.Readdir(-1)
@@ -267,11 +576,11 @@ func loadSources(prefix string, dir string) []*build.Package {
 			continue
 		}
 		if fi.IsDir() {
-			srcs = append(srcs, loadSources(path.Join(prefix, name), filepath.Join(dir, name))...)
+			srcs = append(srcs, loadSources(bctx, path.Join(prefix, name), filepath.Join(dir, name))...)
 		}
 	}
 
-	pkg, err := build.ImportDir(dir, 0)
+	pkg, err := bctx.ImportDir(dir, 0)
 	switch err := err.(type) {
 	case nil:
 		// ImportDir does not know the import path for this package
@@ -287,32 +596,31 @@ func loadSources(prefix string, dir string) []*build.Package {
 	return srcs
 }
 
-func loadDependencies(rootdir string, m map[string]map[string]string, srcs ...*build.Package) []*build.Package {
-	load := func(path string) *build.Package { fatal("cannot resolve path ", path); return nil }
-	for prefix, d := range m {
-		if prefix == "project" {
-			// skip kang metadata
-			continue
-		}
-		if version, ok := d["version"]; ok {
-			load = register(rootdir, prefix, "version", version, load)
-			continue
-		}
-		if tag, ok := d["tag"]; ok {
-			load = register(rootdir, prefix, "tag", tag, load)
-			continue
-		}
-		if commit, ok := d["commit"]; ok {
-			load = register(rootdir, prefix, "commit", commit, load)
-			continue
-		}
-		fatal("unknoww dependency", d)
+// loadDependencies loads the packages imported by srcs which are not part
+// of the project, and those named by imports, resolving them via the
+// dependencies declared in kf.
+// The dependencies are verified against the lock file; commands which
+// build with them write the returned lock once their packages load.
+func loadDependencies(bctx *build.Context, rootdir string, kf *Kangfile, imports []string, srcs ...*build.Package) ([]*build.Package, *lock) {
+	deps, err := resolveDependencies(rootdir, kf)
+	check(err)
+	lock, err := verifyLock(rootdir, deps)
+	check(err)
+	r, err := newResolver(&Kangfile{
+		Project:      kf.Project,
+		Dependencies: deps,
+	})
+	check(err)
+	load := func(path string) *build.Package {
+		d, err := r.resolve(path)
+		check(err)
+		return importDependency(bctx, rootdir, d, path)
 	}
 
 	seen := make(map[string]bool)
 	var walk func(string)
 	walk = func(path string) {
-		if stdlib[path] {
+		if stdlib[path] || path == "C" {
 			return
 		}
 		if seen[path] {
@@ -333,28 +641,29 @@ func loadDependencies(rootdir string, m map[string]map[string]string, srcs ...*b
 			walk(i)
 		}
 	}
-	return srcs
+	for _, i := range imports {
+		walk(i)
+	}
+	return srcs, lock
 }
 
-func register(rootdir, prefix, kind, arg string, next func(string) *build.Package) func(string) *build.Package {
-	dir := cacheDir(rootdir, prefix+kind+"="+arg)
-	fmt.Println("registered:", prefix, "@", arg)
-	return func(path string) *build.Package {
-		if !strings.HasPrefix(path, prefix) {
-			return next(path)
-		}
-		fmt.Println("searching", path, "in", prefix, "@", arg)
-		dir := filepath.Join(dir, path)
-		_, err := os.Stat(dir)
-		if os.IsNotExist(err) {
-			check(err)
-		}
-		return importPath(path, dir)
+// importDependency loads the package path provided by the dependency d
+// from the cache, fetching d into the cache if it is not present.
+func importDependency(bctx *build.Context, rootdir string, d *Dependency, path string) *build.Package {
+	kind, arg := d.Revision()
+	dir := cacheDir(rootdir, d.Prefix+kind+"="+arg)
+	check(fetch(dir, d.Prefix, kind, arg, d.Repo))
+	fmt.Fprintln(os.Stderr, "searching", path, "in", d.Prefix, "@", arg)
+	dir = filepath.Join(dir, path)
+	_, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		check(err)
 	}
+	return importPath(bctx, path, dir)
 }
 
-func importPath(path, dir string) *build.Package {
-	pkg, err := build.ImportDir(dir, 0)
+func importPath(bctx *build.Context, path, dir string) *build.Package {
+	pkg, err := bctx.ImportDir(dir, 0)
 	check(err)
 	// ImportDir does not know the import path for this package
 	// but we know the prefix, so fix it.
@@ -366,3 +675,11 @@ func cacheDir(rootdir, key string) string {
 	hash := sha1.Sum([]byte(key))
 	return filepath.Join(rootdir, ".kang", "cache", fmt.Sprintf("%x", hash[0:1]), fmt.Sprintf("%x", hash[1:]))
 }
+
+func stringList(args ...[]string) []string {
+	var l []string
+	for _, arg := range args {
+		l = append(l, arg...)
+	}
+	return l
+}
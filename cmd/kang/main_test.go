@@ -0,0 +1,165 @@
+package main
+
+import (
+	"go/build"
+	"go/token"
+	"testing"
+)
+
+func TestImportCycleError(t *testing.T) {
+	// frame returns the frame of the package path walking its import of
+	// imp, declared on line of path's a.go if line is not zero.
+	frame := func(path, imp string, line int) *importFrame {
+		src := &build.Package{
+			ImportPath: path,
+			Dir:        "/src/" + path,
+			ImportPos:  make(map[string][]token.Position),
+		}
+		if line > 0 {
+			src.ImportPos[imp] = []token.Position{{Filename: "/src/" + path + "/a.go", Line: line}}
+		}
+		return &importFrame{src: src, imp: imp}
+	}
+
+	tests := []struct {
+		stack []*importFrame
+		path  string
+		want  string
+	}{{
+		stack: []*importFrame{
+			frame("a", "b", 3),
+			frame("b", "a", 4),
+		},
+		path: "a",
+		want: "import cycle not allowed: a -> b -> a\n" +
+			"\t/src/a/a.go:3: a imports b\n" +
+			"\t/src/b/a.go:4: b imports a",
+	}, {
+		// frames below the start of the cycle are left out.
+		stack: []*importFrame{
+			frame("cmd", "a", 5),
+			frame("a", "b", 3),
+			frame("b", "c", 6),
+			frame("c", "a", 7),
+		},
+		path: "a",
+		want: "import cycle not allowed: a -> b -> c -> a\n" +
+			"\t/src/a/a.go:3: a imports b\n" +
+			"\t/src/b/a.go:6: b imports c\n" +
+			"\t/src/c/a.go:7: c imports a",
+	}, {
+		// a package which imports itself.
+		stack: []*importFrame{
+			frame("a", "a", 3),
+		},
+		path: "a",
+		want: "import cycle not allowed: a -> a\n" +
+			"\t/src/a/a.go:3: a imports a",
+	}, {
+		// implicit imports have no position, the directory is reported.
+		stack: []*importFrame{
+			frame("a", "b", 3),
+			frame("b", "a", 0),
+		},
+		path: "a",
+		want: "import cycle not allowed: a -> b -> a\n" +
+			"\t/src/a/a.go:3: a imports b\n" +
+			"\t/src/b: b imports a",
+	}}
+	for i, tt := range tests {
+		err := importCycleError(tt.stack, tt.path)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("%d: importCycleError: got error %v, want %q", i, err, tt.want)
+		}
+	}
+}
+
+func TestInternalParent(t *testing.T) {
+	tests := []struct {
+		path   string
+		parent string
+		ok     bool
+	}{
+		{"example.com/p/internal/x", "example.com/p", true},
+		{"example.com/p/internal", "example.com/p", true},
+		{"example.com/p/a/internal/b/internal/c", "example.com/p/a/internal/b", true}, // the last internal element
+		{"example.com/p/internal/x/y", "example.com/p", true},
+		{"internal/poll", "", true}, // the standard library's
+		{"internal", "", true},
+		{"vendor/golang.org/x/net/internal/x", "vendor/golang.org/x/net", true},
+		{"example.com/p/internals/x", "", false},
+		{"example.com/p/x", "", false},
+	}
+	for _, tt := range tests {
+		parent, ok := internalParent(tt.path)
+		if parent != tt.parent || ok != tt.ok {
+			t.Errorf("internalParent(%q): got %q, %v, want %q, %v", tt.path, parent, ok, tt.parent, tt.ok)
+		}
+	}
+}
+
+func TestCheckInternal(t *testing.T) {
+	src := func(path string, goroot bool) *build.Package {
+		return &build.Package{ImportPath: path, Dir: "/src/" + path, Goroot: goroot}
+	}
+	tests := []struct {
+		src  *build.Package
+		dep  string
+		want string // error, blank if the import is allowed
+	}{
+		{src: src("example.com/p", false), dep: "example.com/p/internal/x"},
+		{src: src("example.com/p/cmd/p", false), dep: "example.com/p/internal/x"},
+		{src: src("example.com/p/internal/y", false), dep: "example.com/p/internal/x"},
+		{src: src("example.com/p", false), dep: "example.com/p/x"},
+		{
+			src:  src("example.com/q", false),
+			dep:  "example.com/p/internal/x",
+			want: "/src/example.com/q: use of internal package example.com/p/internal/x not allowed: example.com/q is not inside example.com/p",
+		}, {
+			src:  src("example.com/pp", false),
+			dep:  "example.com/p/internal/x",
+			want: "/src/example.com/pp: use of internal package example.com/p/internal/x not allowed: example.com/pp is not inside example.com/p",
+		},
+
+		// nested internal directories; the innermost decides.
+		{src: src("example.com/p/a/internal/b/d", false), dep: "example.com/p/a/internal/b/internal/c"},
+		{
+			src:  src("example.com/p/a/e", false),
+			dep:  "example.com/p/a/internal/b/internal/c",
+			want: "/src/example.com/p/a/e: use of internal package example.com/p/a/internal/b/internal/c not allowed: example.com/p/a/e is not inside example.com/p/a/internal/b",
+		},
+
+		// internal packages at the root of the standard library.
+		{src: src("os", true), dep: "internal/poll"},
+		{
+			src:  src("example.com/p", false),
+			dep:  "internal/poll",
+			want: "/src/example.com/p: use of internal package internal/poll not allowed: example.com/p is not inside the standard library",
+		},
+
+		// internal packages of vendored copies in the standard library.
+		{src: src("vendor/golang.org/x/net/http2", true), dep: "vendor/golang.org/x/net/internal/x"},
+		{
+			src:  src("net/http", true),
+			dep:  "vendor/golang.org/x/net/internal/x",
+			want: "/src/net/http: use of internal package vendor/golang.org/x/net/internal/x not allowed: net/http is not inside vendor/golang.org/x/net",
+		},
+	}
+	for _, tt := range tests {
+		err := checkInternal(tt.src, tt.dep, &build.Package{ImportPath: tt.dep})
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("checkInternal(%s imports %s): %v", tt.src.ImportPath, tt.dep, err)
+		case tt.want != "" && (err == nil || err.Error() != tt.want):
+			t.Errorf("checkInternal(%s imports %s): got error %v, want %q", tt.src.ImportPath, tt.dep, err, tt.want)
+		}
+	}
+
+	// the position of the import declaration is reported, if known.
+	q := src("example.com/q", false)
+	q.ImportPos = map[string][]token.Position{"example.com/p/internal/x": {{Filename: "/src/example.com/q/q.go", Line: 5}}}
+	err := checkInternal(q, "example.com/p/internal/x", &build.Package{ImportPath: "example.com/p/internal/x"})
+	if want := "/src/example.com/q/q.go:5: use of internal package example.com/p/internal/x not allowed: example.com/q is not inside example.com/p"; err == nil || err.Error() != want {
+		t.Errorf("checkInternal: got error %v, want %q", err, want)
+	}
+}
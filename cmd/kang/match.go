@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// matchPackages returns the import paths of the packages named by
+// patterns, or of every package in srcs, the packages of proj, if
+// patterns is empty. A pattern is one of
+//
+//	a directory, relative to the current directory, or absolute
+//	the import path of a package in the project
+//	the import path of a package provided by a dependency
+//	the import path of a standard library package
+//
+// Directories and project import paths may end in /..., which matches
+// the named package and every package below it.
+//
+// Import paths outside the project are returned as given; they are
+// resolved against the standard library and the project's dependencies
+// when loaded.
+func matchPackages(proj *project, srcs []*build.Package, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		var paths []string
+		for _, src := range srcs {
+			paths = append(paths, src.ImportPath)
+		}
+		return paths, nil
+	}
+
+	var paths []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	for _, pattern := range patterns {
+		p, err := importPattern(proj, pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		wildcard := strings.HasSuffix(p, "/...")
+		p = strings.TrimSuffix(p, "/...")
+		if !hasPathPrefix(p, proj.prefix) {
+			if wildcard {
+				return nil, fmt.Errorf("%s: /... patterns may only name packages in the project", pattern)
+			}
+			add(p)
+			continue
+		}
+
+		var matched bool
+		for _, src := range srcs {
+			if src.ImportPath == p || wildcard && hasPathPrefix(src.ImportPath, p) {
+				add(src.ImportPath)
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%s: no packages in the project match", pattern)
+		}
+	}
+	return paths, nil
+}
+
+// importPattern converts pattern to an import path pattern. Directories
+// are converted to the import path of the project package they contain.
+func importPattern(proj *project, pattern string) (string, error) {
+	if !build.IsLocalImport(pattern) && !filepath.IsAbs(pattern) {
+		return pattern, nil
+	}
+
+	dir, wildcard := pattern, false
+	if strings.HasSuffix(dir, "/...") {
+		dir, wildcard = strings.TrimSuffix(dir, "..."), true
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(proj.rootdir, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: directory is outside the project %s", pattern, proj.rootdir)
+	}
+
+	p := path.Join(proj.prefix, filepath.ToSlash(rel))
+	if wildcard {
+		p += "/..."
+	}
+	return p, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"go/build"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImportPattern(t *testing.T) {
+	root := filepath.FromSlash("/home/p")
+	proj := &project{rootdir: root, prefix: "example.com/p"}
+
+	tests := []struct {
+		pattern string
+		want    string
+		err     string // substring of the expected error
+	}{
+		{pattern: "example.com/p/a", want: "example.com/p/a"},
+		{pattern: "example.com/p/...", want: "example.com/p/..."},
+		{pattern: "github.com/pkg/errors", want: "github.com/pkg/errors"},
+		{pattern: "fmt", want: "fmt"},
+		{pattern: root, want: "example.com/p"},
+		{pattern: filepath.Join(root, "a"), want: "example.com/p/a"},
+		{pattern: filepath.Join(root, "a", "b"), want: "example.com/p/a/b"},
+		{pattern: root + "/...", want: "example.com/p/..."},
+		{pattern: filepath.Join(root, "a") + "/...", want: "example.com/p/a/..."},
+		{pattern: filepath.Join(root, "a", ".."), want: "example.com/p"},
+		{pattern: filepath.FromSlash("/home"), err: "directory is outside the project"},
+		{pattern: filepath.FromSlash("/home/pq"), err: "directory is outside the project"},
+		{pattern: filepath.Join(root, ".."), err: "directory is outside the project"},
+		{pattern: filepath.FromSlash("/home/..."), err: "directory is outside the project"},
+	}
+	for _, tt := range tests {
+		got, err := importPattern(proj, tt.pattern)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("importPattern(%q): got error %v, want %q", tt.pattern, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("importPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("importPattern(%q): got %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPackages(t *testing.T) {
+	root := filepath.FromSlash("/home/p")
+	proj := &project{rootdir: root, prefix: "example.com/p"}
+	var srcs []*build.Package
+	for _, p := range []string{"example.com/p", "example.com/p/a", "example.com/p/a/b", "example.com/p/ab", "example.com/p/cmd/c"} {
+		srcs = append(srcs, &build.Package{ImportPath: p})
+	}
+
+	tests := []struct {
+		patterns []string
+		want     []string
+		err      string // substring of the expected error
+	}{{
+		// no patterns match every package in the project.
+		want: []string{"example.com/p", "example.com/p/a", "example.com/p/a/b", "example.com/p/ab", "example.com/p/cmd/c"},
+	}, {
+		patterns: []string{"example.com/p/a"},
+		want:     []string{"example.com/p/a"},
+	}, {
+		patterns: []string{"example.com/p/a/..."},
+		want:     []string{"example.com/p/a", "example.com/p/a/b"}, // whole elements only
+	}, {
+		patterns: []string{"example.com/p/cmd/..."},
+		want:     []string{"example.com/p/cmd/c"},
+	}, {
+		patterns: []string{filepath.Join(root, "a"), filepath.Join(root, "cmd") + "/..."},
+		want:     []string{"example.com/p/a", "example.com/p/cmd/c"},
+	}, {
+		// each package is returned once, in the order first matched.
+		patterns: []string{"example.com/p/a/b", "example.com/p/a/...", filepath.Join(root, "a")},
+		want:     []string{"example.com/p/a/b", "example.com/p/a"},
+	}, {
+		// packages outside the project are returned as given.
+		patterns: []string{"github.com/pkg/errors", "fmt", "example.com/p/ab"},
+		want:     []string{"github.com/pkg/errors", "fmt", "example.com/p/ab"},
+	}, {
+		patterns: []string{"example.com/p/missing"},
+		err:      "example.com/p/missing: no packages in the project match",
+	}, {
+		patterns: []string{"example.com/p/missing/..."},
+		err:      "example.com/p/missing/...: no packages in the project match",
+	}, {
+		patterns: []string{"github.com/pkg/..."},
+		err:      "github.com/pkg/...: /... patterns may only name packages in the project",
+	}, {
+		patterns: []string{filepath.FromSlash("/home/q")},
+		err:      "directory is outside the project",
+	}}
+	for _, tt := range tests {
+		got, err := matchPackages(proj, srcs, tt.patterns)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("matchPackages(%q): got error %v, want %q", tt.patterns, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("matchPackages(%q): %v", tt.patterns, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("matchPackages(%q): got %q, want %q", tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestImportedSources(t *testing.T) {
+	srcs := []*build.Package{
+		{ImportPath: "example.com/p/a", Imports: []string{"example.com/p/b", "fmt"}, TestImports: []string{"example.com/p/c"}},
+		{ImportPath: "example.com/p/b", Imports: []string{"github.com/pkg/errors"}},
+		{ImportPath: "example.com/p/c", XTestImports: []string{"example.com/p/d"}},
+		{ImportPath: "example.com/p/d"},
+	}
+	paths := func(srcs []*build.Package) []string {
+		var paths []string
+		for _, src := range srcs {
+			paths = append(paths, src.ImportPath)
+		}
+		return paths
+	}
+
+	tests := []struct {
+		paths []string
+		tests bool
+		want  []string
+	}{
+		{paths: []string{"example.com/p/a"}, want: []string{"example.com/p/b", "example.com/p/a"}},
+		{paths: []string{"example.com/p/a"}, tests: true, want: []string{"example.com/p/b", "example.com/p/a", "example.com/p/c"}},
+		{paths: []string{"example.com/p/c"}, want: []string{"example.com/p/c"}},
+		{paths: []string{"example.com/p/c"}, tests: true, want: []string{"example.com/p/c", "example.com/p/d"}},
+		{paths: []string{"example.com/p/b", "github.com/pkg/errors"}, want: []string{"example.com/p/b"}},
+	}
+	for _, tt := range tests {
+		if got := paths(importedSources(srcs, tt.paths, tt.tests)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("importedSources(%q, %v): got %q, want %q", tt.paths, tt.tests, got, tt.want)
+		}
+	}
+}
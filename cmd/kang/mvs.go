@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requirement records a dependency declaration and who declared it.
+type requirement struct {
+	dep Dependency
+	by  string // dependency whose .kangfile declared dep, blank for the project
+}
+
+func (r *requirement) String() string {
+	kind, arg := r.dep.Revision()
+	if r.by == "" {
+		return fmt.Sprintf("%s=%s required by the project (%s)", kind, arg, r.dep.declaredAt())
+	}
+	return fmt.Sprintf("%s=%s required by %s", kind, arg, r.by)
+}
+
+// resolveDependencies returns the dependencies of the project described
+// by kf: those declared in its .kangfile, along with those declared by the
+// .kangfiles of its dependencies, recursively. Each dependency is fetched
+// into the cache so its .kangfile can be read, and the modification time
+// of its cache entry is updated to record its use.
+//
+// When a dependency is required more than once, a single declaration is
+// selected. A tag= or commit= pin in the project's own .kangfile always
+// wins. If every requirement is a version=, each is treated as a minimum
+// and the highest is selected, as in minimal version selection. Otherwise
+// the requirements must agree exactly; any difference is reported as a
+// conflict naming who required what.
+//
+// The repository a dependency is fetched from is that given by the
+// project's .kangfile, if it declares the dependency; a repo= in the
+// .kangfile of a dependency is ignored. Otherwise it is the repo= given
+// by the .kangfiles of the dependencies which require it, which must
+// agree.
+func resolveDependencies(rootdir string, kf *Kangfile) ([]Dependency, error) {
+	g, err := loadRequirements(rootdir, kf, true)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, dir := range g.entries {
+		if err := os.Chtimes(dir, now, now); err != nil {
+			return nil, err
+		}
+	}
+
+	var deps []Dependency
+	for _, prefix := range g.order {
+		d, err := selectDependency(prefix, g.reqs[prefix])
+		if err != nil {
+			return nil, err
+		}
+		d.Repo = g.repos[prefix].dep.Repo
+		deps = append(deps, d)
+	}
+	return deps, nil
+}
+
+// requirementGraph holds the requirements declared by a project's
+// .kangfile and the .kangfiles of its dependencies.
+type requirementGraph struct {
+	reqs    map[string][]requirement // requirements of each prefix
+	repos   map[string]requirement   // requirement giving the repository of each prefix
+	order   []string                 // prefixes in the order they were first required
+	entries []string                 // cache entries of every requirement
+}
+
+// repo returns the repository prefix is fetched from, as required by r.
+// It is an error for dependencies to require prefix from different
+// repositories, unless the project declares prefix.
+func (g *requirementGraph) repo(r requirement) (string, error) {
+	prefix := r.dep.Prefix
+	prev, ok := g.repos[prefix]
+	switch {
+	case ok && prev.by == "":
+		// the project's .kangfile decides.
+	case r.dep.Repo == "":
+		// no opinion.
+	case !ok || prev.dep.Repo == "":
+		g.repos[prefix] = r
+	case prev.dep.Repo != r.dep.Repo:
+		return "", fmt.Errorf("conflicting repositories for %s:\n\trepo=%s required by %s\n\trepo=%s required by %s\ndeclare %s with repo= in the project's .kangfile to choose one", prefix, prev.dep.Repo, prev.by, r.dep.Repo, r.by, prefix)
+	}
+	return g.repos[prefix].dep.Repo, nil
+}
+
+// loadRequirements gathers the requirements of the project described by
+// kf. If fetchMissing is false, requirements missing from the cache are
+// not fetched, nor are the requirements they declare gathered.
+func loadRequirements(rootdir string, kf *Kangfile, fetchMissing bool) (*requirementGraph, error) {
+	g := requirementGraph{
+		reqs:  make(map[string][]requirement),
+		repos: make(map[string]requirement),
+	}
+	seen := make(map[string]bool)
+
+	queue := make([]requirement, 0, len(kf.Dependencies))
+	for _, d := range kf.Dependencies {
+		r := requirement{dep: d}
+		g.repos[d.Prefix] = r
+		queue = append(queue, r)
+	}
+	for len(queue) > 0 {
+		r := queue[0]
+		queue = queue[1:]
+
+		prefix := r.dep.Prefix
+		if r.by != "" && hasPathPrefix(prefix, kf.Project.Prefix) {
+			// a dependency which depends on this project.
+			continue
+		}
+		if _, ok := g.reqs[prefix]; !ok {
+			g.order = append(g.order, prefix)
+		}
+		g.reqs[prefix] = append(g.reqs[prefix], r)
+		repo, err := g.repo(r)
+		if err != nil {
+			return nil, err
+		}
+
+		kind, arg := r.dep.Revision()
+		key := prefix + kind + "=" + arg
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		dir := cacheDir(rootdir, key)
+		if fetchMissing {
+			if err := fetch(dir, prefix, kind, arg, repo); err != nil {
+				return nil, err
+			}
+		} else if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		g.entries = append(g.entries, dir)
+
+		sub, err := ParseFile(filepath.Join(dir, filepath.FromSlash(prefix), ".kangfile"))
+		if os.IsNotExist(err) {
+			continue // no transitive dependencies declared
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range sub.Dependencies {
+			queue = append(queue, requirement{dep: d, by: prefix + "@" + kind + "=" + arg})
+		}
+	}
+	return &g, nil
+}
+
+// selectDependency selects the declaration of prefix to use from reqs.
+// See resolveDependencies for the rules.
+func selectDependency(prefix string, reqs []requirement) (Dependency, error) {
+	var root *requirement
+	pinned := false
+	for i := range reqs {
+		if reqs[i].by == "" {
+			root = &reqs[i]
+		}
+		if reqs[i].dep.Version == "" {
+			pinned = true
+		}
+	}
+
+	var sel *requirement
+	switch {
+	case root != nil && root.dep.Version == "":
+		sel = root
+	case !pinned:
+		for i := range reqs {
+			r := &reqs[i]
+			if _, err := parseSemver(r.dep.Version); err != nil {
+				return Dependency{}, fmt.Errorf("%s: %s: %v", prefix, r, err)
+			}
+			if sel == nil || compareSemver(r.dep.Version, sel.dep.Version) > 0 {
+				sel = r
+			}
+		}
+	default:
+		sel = &reqs[0]
+		for i := range reqs[1:] {
+			r := &reqs[i+1]
+			if r.dep.Version != sel.dep.Version || r.dep.Tag != sel.dep.Tag || r.dep.Commit != sel.dep.Commit {
+				return Dependency{}, conflictError(prefix, reqs)
+			}
+		}
+	}
+
+	d := sel.dep
+	d.RequiredBy = sel.by
+	return d, nil
+}
+
+func conflictError(prefix string, reqs []requirement) error {
+	var buf []string
+	for i := range reqs {
+		buf = append(buf, "\t"+reqs[i].String())
+	}
+	return fmt.Errorf("conflicting requirements for %s:\n%s\npin %s with tag= or commit= in the project's .kangfile to choose one", prefix, strings.Join(buf, "\n"), prefix)
+}
+
+// semver is a parsed version=MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD].
+type semver struct {
+	major, minor, patch int
+	pre                 []string // dot separated prerelease identifiers
+}
+
+func parseSemver(v string) (semver, error) {
+	var sv semver
+	s := strings.TrimPrefix(v, "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i] // build metadata does not affect precedence
+	}
+	if i := strings.Index(s, "-"); i >= 0 {
+		sv.pre = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return sv, fmt.Errorf("%q is not a semantic version", v)
+	}
+	nums := []*int{&sv.major, &sv.minor, &sv.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return sv, fmt.Errorf("%q is not a semantic version", v)
+		}
+		*nums[i] = n
+	}
+	return sv, nil
+}
+
+// compareSemver returns -1, 0 or 1 as a is lower, equal or higher
+// precedence than b. Both must be valid semantic versions.
+func compareSemver(a, b string) int {
+	x, _ := parseSemver(a)
+	y, _ := parseSemver(b)
+	for _, c := range [][2]int{{x.major, y.major}, {x.minor, y.minor}, {x.patch, y.patch}} {
+		if c[0] != c[1] {
+			return cmpInt(c[0], c[1])
+		}
+	}
+
+	// a version without a prerelease has higher precedence.
+	switch {
+	case len(x.pre) == 0 && len(y.pre) == 0:
+		return 0
+	case len(x.pre) == 0:
+		return 1
+	case len(y.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(x.pre) && i < len(y.pre); i++ {
+		p, q := x.pre[i], y.pre[i]
+		if p == q {
+			continue
+		}
+		pn, perr := strconv.Atoi(p)
+		qn, qerr := strconv.Atoi(q)
+		switch {
+		case perr == nil && qerr == nil:
+			return cmpInt(pn, qn)
+		case perr == nil:
+			return -1 // numeric identifiers have lower precedence
+		case qerr == nil:
+			return 1
+		case p < q:
+			return -1
+		default:
+			return 1
+		}
+	}
+	return cmpInt(len(x.pre), len(y.pre))
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompareSemver(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"v1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"1", "1.0.0", 0},
+		{"1.0.0+build", "1.0.0", 0},
+		{"1.0.0", "1.0.1", -1},
+		{"1.2.0", "1.10.0", -1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.0.0-alpha", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta", "1.0.0-beta.2", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+	}
+	for _, tt := range tests {
+		if got := compareSemver(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareSemver(%q, %q): got %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareSemver(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareSemver(%q, %q): got %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseSemverError(t *testing.T) {
+	for _, v := range []string{"", "x", "1.0.0.0", "1.x.0", "1.-1.0", "v"} {
+		if _, err := parseSemver(v); err == nil {
+			t.Errorf("parseSemver(%q): want error", v)
+		}
+	}
+}
+
+func TestSelectDependency(t *testing.T) {
+	const prefix = "example.com/a"
+	root := func(d Dependency) requirement {
+		d.Prefix, d.Line = prefix, 2
+		return requirement{dep: d}
+	}
+	by := func(who string, d Dependency) requirement {
+		d.Prefix = prefix
+		return requirement{dep: d, by: who}
+	}
+
+	tests := []struct {
+		reqs []requirement
+		want Dependency // Prefix is implied
+		err  string     // substring of the expected error
+	}{{
+		reqs: []requirement{root(Dependency{Version: "1.0.0"})},
+		want: Dependency{Version: "1.0.0", Line: 2},
+	}, {
+		// the highest version= wins.
+		reqs: []requirement{
+			root(Dependency{Version: "1.0.0"}),
+			by("example.com/b@version=1.0.0", Dependency{Version: "1.2.0"}),
+			by("example.com/c@version=1.0.0", Dependency{Version: "1.1.0"}),
+		},
+		want: Dependency{Version: "1.2.0", RequiredBy: "example.com/b@version=1.0.0"},
+	}, {
+		reqs: []requirement{
+			root(Dependency{Version: "1.10.0"}),
+			by("example.com/b@version=1.0.0", Dependency{Version: "1.9.0"}),
+		},
+		want: Dependency{Version: "1.10.0", Line: 2},
+	}, {
+		// a pin in the project's .kangfile always wins.
+		reqs: []requirement{
+			root(Dependency{Tag: "v0.9.0"}),
+			by("example.com/b@version=1.0.0", Dependency{Version: "1.2.0"}),
+			by("example.com/c@version=1.0.0", Dependency{Commit: "0123abc"}),
+		},
+		want: Dependency{Tag: "v0.9.0", Line: 2},
+	}, {
+		// pins required by dependencies must agree.
+		reqs: []requirement{
+			by("example.com/b@version=1.0.0", Dependency{Tag: "v1.0.0"}),
+			by("example.com/c@version=1.0.0", Dependency{Tag: "v1.0.0"}),
+		},
+		want: Dependency{Tag: "v1.0.0", RequiredBy: "example.com/b@version=1.0.0"},
+	}, {
+		reqs: []requirement{
+			root(Dependency{Version: "1.0.0"}),
+			by("example.com/b@version=1.0.0", Dependency{Tag: "v1.0.0"}),
+		},
+		err: "conflicting requirements for example.com/a:\n\tversion=1.0.0 required by the project (line 2 of the .kangfile)\n\ttag=v1.0.0 required by example.com/b@version=1.0.0\n",
+	}, {
+		reqs: []requirement{
+			by("example.com/b@version=1.0.0", Dependency{Tag: "v1.0.0"}),
+			by("example.com/c@version=1.0.0", Dependency{Commit: "0123abc"}),
+		},
+		err: "conflicting requirements for example.com/a",
+	}, {
+		reqs: []requirement{
+			root(Dependency{Version: "1.0.0"}),
+			by("example.com/b@version=1.0.0", Dependency{Version: "latest"}),
+		},
+		err: `example.com/a: version=latest required by example.com/b@version=1.0.0: "latest" is not a semantic version`,
+	}}
+	for i, tt := range tests {
+		got, err := selectDependency(prefix, tt.reqs)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%d: selectDependency: got error %v, want %q", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: selectDependency: %v", i, err)
+			continue
+		}
+		tt.want.Prefix = prefix
+		if got != tt.want {
+			t.Errorf("%d: selectDependency: got %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
+
+func TestRequirementGraphRepo(t *testing.T) {
+	const prefix = "example.com/a"
+	req := func(by, repo string) requirement {
+		return requirement{dep: Dependency{Prefix: prefix, Version: "1.0.0", Repo: repo}, by: by}
+	}
+
+	tests := []struct {
+		root *requirement // the project's declaration, if any
+		reqs []requirement
+		want string // repository, after reqs
+		err  string // substring of the expected error
+	}{{
+		reqs: []requirement{req("b", "https://b.example.com/a.git")},
+		want: "https://b.example.com/a.git",
+	}, {
+		reqs: []requirement{req("b", ""), req("c", "https://c.example.com/a.git"), req("d", "")},
+		want: "https://c.example.com/a.git",
+	}, {
+		reqs: []requirement{req("b", "https://b.example.com/a.git"), req("c", "https://b.example.com/a.git")},
+		want: "https://b.example.com/a.git",
+	}, {
+		// the project's .kangfile decides, even if it gives no repo=.
+		root: &requirement{dep: Dependency{Prefix: prefix, Version: "1.0.0"}},
+		reqs: []requirement{req("b", "https://evil.example.com/a.git")},
+		want: "",
+	}, {
+		root: &requirement{dep: Dependency{Prefix: prefix, Version: "1.0.0", Repo: "https://example.com/a.git"}},
+		reqs: []requirement{req("b", "https://b.example.com/a.git"), req("c", "https://c.example.com/a.git")},
+		want: "https://example.com/a.git",
+	}, {
+		reqs: []requirement{req("b", "https://b.example.com/a.git"), req("c", "https://c.example.com/a.git")},
+		err:  "conflicting repositories for example.com/a:\n\trepo=https://b.example.com/a.git required by b\n\trepo=https://c.example.com/a.git required by c\n",
+	}}
+	for i, tt := range tests {
+		g := requirementGraph{repos: make(map[string]requirement)}
+		reqs := tt.reqs
+		if tt.root != nil {
+			g.repos[prefix] = *tt.root
+			reqs = append([]requirement{*tt.root}, reqs...)
+		}
+		var got string
+		var err error
+		for _, r := range reqs {
+			if got, err = g.repo(r); err != nil {
+				break
+			}
+		}
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%d: repo: got error %v, want %q", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d: repo: %v", i, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%d: repo: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// resolver maps import paths to the dependency which provides them.
+// Dependencies are indexed by the elements of their prefix, so an import
+// path is resolved to the dependency with the longest prefix matching
+// whole path elements; github.com/pkg/err does not provide
+// github.com/pkg/errors.
+type resolver struct {
+	project string // import path prefix of the project
+	root    node
+	deps    []*Dependency
+}
+
+type node struct {
+	children map[string]*node
+	dep      *Dependency // dependency declared at this prefix, if any
+}
+
+// newResolver returns a resolver for the dependencies declared in kf.
+// Declarations which name the same prefix, or which overlap the prefix
+// of the project itself, are reported as errors.
+func newResolver(kf *Kangfile) (*resolver, error) {
+	r := resolver{
+		project: kf.Project.Prefix,
+	}
+	for i := range kf.Dependencies {
+		d := &kf.Dependencies[i]
+		prefix := path.Clean(d.Prefix)
+		if prefix == "." || strings.HasPrefix(prefix, "/") || strings.HasPrefix(prefix, "../") {
+			return nil, fmt.Errorf("%s: invalid import path prefix (%s)", d.Prefix, d.declaredAt())
+		}
+		if r.project != "" && (hasPathPrefix(prefix, r.project) || hasPathPrefix(r.project, prefix)) {
+			return nil, fmt.Errorf("%s: overlaps the project prefix %s (%s)", d.Prefix, r.project, d.declaredAt())
+		}
+
+		n := &r.root
+		for _, elem := range strings.Split(prefix, "/") {
+			if n.children == nil {
+				n.children = make(map[string]*node)
+			}
+			child, ok := n.children[elem]
+			if !ok {
+				child = new(node)
+				n.children[elem] = child
+			}
+			n = child
+		}
+		if n.dep != nil {
+			return nil, fmt.Errorf("%s: ambiguous declaration (%s), %s is already declared (%s)", d.Prefix, d.declaredAt(), n.dep.Prefix, n.dep.declaredAt())
+		}
+		n.dep = d
+		r.deps = append(r.deps, d)
+	}
+	return &r, nil
+}
+
+// resolve returns the dependency which provides the package path.
+// If no dependency provides path, the error explains which declared
+// dependency, if any, was the closest match.
+func (r *resolver) resolve(path string) (*Dependency, error) {
+	var dep *Dependency
+	n := &r.root
+	for _, elem := range strings.Split(path, "/") {
+		n = n.children[elem]
+		if n == nil {
+			break
+		}
+		if n.dep != nil {
+			dep = n.dep // longest match so far
+		}
+	}
+	if dep != nil {
+		return dep, nil
+	}
+
+	if hasPathPrefix(path, r.project) {
+		return nil, fmt.Errorf("cannot resolve import path %s: no such package in project %s", path, r.project)
+	}
+	if closest := r.closest(path); closest != nil {
+		return nil, fmt.Errorf("cannot resolve import path %s: no dependency in the .kangfile provides it, the closest is %s (%s)", path, closest.Prefix, closest.declaredAt())
+	}
+	return nil, fmt.Errorf("cannot resolve import path %s: no dependency in the .kangfile provides it", path)
+}
+
+// closest returns the declared dependency whose prefix shares the longest
+// leading substring with path, or nil if none share the first path element.
+func (r *resolver) closest(path string) *Dependency {
+	var best *Dependency
+	var bestLen int
+	for _, d := range r.deps {
+		if !hasPathPrefix(path, strings.SplitN(d.Prefix, "/", 2)[0]) {
+			continue // different host
+		}
+		n := commonPrefixLen(path, d.Prefix)
+		if best == nil || n > bestLen || (n == bestLen && d.Prefix < best.Prefix) {
+			best, bestLen = d, n
+		}
+	}
+	return best
+}
+
+// hasPathPrefix reports whether the import path s begins with the path
+// elements of prefix.
+func hasPathPrefix(s, prefix string) bool {
+	return s == prefix || strings.HasPrefix(s, prefix+"/")
+}
+
+func commonPrefixLen(a, b string) int {
+	var i int
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
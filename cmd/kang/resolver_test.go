@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	kf := &Kangfile{
+		Project: Project{Prefix: "example.com/p"},
+		Dependencies: []Dependency{
+			{Prefix: "github.com/pkg/errors", Version: "0.8.0", Line: 2},
+			{Prefix: "golang.org/x/net", Tag: "v1", Line: 3},
+			{Prefix: "golang.org/x/net/http2", Tag: "v2", Line: 4},
+			{Prefix: "github.com/pkg/sftp", Version: "0.2.1", Line: 5},
+		},
+	}
+	r, err := newResolver(kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want string // prefix of the dependency, blank for an error
+		err  string // substring of the expected error
+	}{
+		{path: "github.com/pkg/errors", want: "github.com/pkg/errors"},
+		{path: "github.com/pkg/errors/sub", want: "github.com/pkg/errors"},
+		{path: "golang.org/x/net/context", want: "golang.org/x/net"},
+		{path: "golang.org/x/net/http2", want: "golang.org/x/net/http2"},              // longest prefix wins
+		{path: "golang.org/x/net/http2/hpack", want: "golang.org/x/net/http2"},        // longest prefix wins
+		{path: "golang.org/x/net/http2x", want: "golang.org/x/net"},                   // whole elements only
+		{path: "github.com/pkg/err", err: "the closest is github.com/pkg/errors"},     // a prefix of a dependency
+		{path: "github.com/pkg/errorsx", err: "the closest is github.com/pkg/errors"}, // whole elements only
+		{path: "github.com/pkg/s", err: "the closest is github.com/pkg/sftp (line 5 of the .kangfile)"},
+		{path: "golang.org/x/text", err: "the closest is golang.org/x/net (line 3 of the .kangfile)"},
+		{path: "example.org/a", err: "no dependency in the .kangfile provides it"},
+		{path: "example.com/p/missing", err: "no such package in project example.com/p"},
+	}
+	for _, tt := range tests {
+		d, err := r.resolve(tt.path)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("resolve(%q): got error %v, want %q", tt.path, err, tt.err)
+			} else if strings.Contains(err.Error(), "closest") && !strings.Contains(tt.err, "closest") {
+				t.Errorf("resolve(%q): got error %v, want no closest match", tt.path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolve(%q): %v", tt.path, err)
+			continue
+		}
+		if d.Prefix != tt.want {
+			t.Errorf("resolve(%q): got %s, want %s", tt.path, d.Prefix, tt.want)
+		}
+	}
+}
+
+func TestNewResolverError(t *testing.T) {
+	tests := []struct {
+		deps []Dependency
+		err  string
+	}{{
+		deps: []Dependency{{Prefix: "example.com/p/vendor/a", Line: 2}},
+		err:  "example.com/p/vendor/a: overlaps the project prefix example.com/p (line 2 of the .kangfile)",
+	}, {
+		deps: []Dependency{{Prefix: "example.com", Line: 2}},
+		err:  "example.com: overlaps the project prefix example.com/p (line 2 of the .kangfile)",
+	}, {
+		deps: []Dependency{{Prefix: "/a", Line: 2}},
+		err:  "/a: invalid import path prefix (line 2 of the .kangfile)",
+	}, {
+		deps: []Dependency{{Prefix: "../a", Line: 2}},
+		err:  "../a: invalid import path prefix (line 2 of the .kangfile)",
+	}, {
+		deps: []Dependency{
+			{Prefix: "example.org/a", Line: 2},
+			{Prefix: "example.org/a/", Line: 3},
+		},
+		err: "example.org/a/: ambiguous declaration (line 3 of the .kangfile), example.org/a is already declared (line 2 of the .kangfile)",
+	}, {
+		deps: []Dependency{
+			{Prefix: "example.org/a", Line: 2},
+			{Prefix: "example.org/a", RequiredBy: "example.org/b@version=1.0.0"},
+		},
+		err: "example.org/a: ambiguous declaration (required by example.org/b@version=1.0.0), example.org/a is already declared (line 2 of the .kangfile)",
+	}}
+	for _, tt := range tests {
+		_, err := newResolver(&Kangfile{
+			Project:      Project{Prefix: "example.com/p"},
+			Dependencies: tt.deps,
+		})
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("newResolver(%v): got error %v, want %q", tt.deps, err, tt.err)
+		}
+	}
+}
+
+func TestClosest(t *testing.T) {
+	r, err := newResolver(&Kangfile{
+		Dependencies: []Dependency{
+			{Prefix: "github.com/pkg/errors"},
+			{Prefix: "github.com/pkg/profile"},
+			{Prefix: "github.com/davecgh/go-spew"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path, want string // want is blank if nothing is close
+	}{
+		{"github.com/pkg/prof", "github.com/pkg/profile"},
+		{"github.com/pkg/e", "github.com/pkg/errors"},
+		{"github.com/pkg/x", "github.com/pkg/errors"}, // ties are broken by prefix
+		{"github.com/dave", "github.com/davecgh/go-spew"},
+		{"gitlab.com/pkg/errors", ""}, // different host
+	}
+	for _, tt := range tests {
+		var got string
+		if d := r.closest(tt.path); d != nil {
+			got = d.Prefix
+		}
+		if got != tt.want {
+			t.Errorf("closest(%q): got %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -1,177 +1,122 @@
 package main
 
-var stdlib = map[string]bool{
-	"archive/tar":                       true,
-	"archive/zip":                       true,
-	"bufio":                             true,
-	"bytes":                             true,
-	"compress/bzip2":                    true,
-	"compress/flate":                    true,
-	"compress/gzip":                     true,
-	"compress/lzw":                      true,
-	"compress/zlib":                     true,
-	"container/heap":                    true,
-	"container/list":                    true,
-	"container/ring":                    true,
-	"context":                           true,
-	"crypto":                            true,
-	"crypto/aes":                        true,
-	"crypto/cipher":                     true,
-	"crypto/des":                        true,
-	"crypto/dsa":                        true,
-	"crypto/ecdsa":                      true,
-	"crypto/elliptic":                   true,
-	"crypto/hmac":                       true,
-	"crypto/internal/cipherhw":          true,
-	"crypto/md5":                        true,
-	"crypto/rand":                       true,
-	"crypto/rc4":                        true,
-	"crypto/rsa":                        true,
-	"crypto/sha1":                       true,
-	"crypto/sha256":                     true,
-	"crypto/sha512":                     true,
-	"crypto/subtle":                     true,
-	"crypto/tls":                        true,
-	"crypto/x509":                       true,
-	"crypto/x509/pkix":                  true,
-	"database/sql":                      true,
-	"database/sql/driver":               true,
-	"database/sql/internal":             true,
-	"debug/dwarf":                       true,
-	"debug/elf":                         true,
-	"debug/gosym":                       true,
-	"debug/macho":                       true,
-	"debug/pe":                          true,
-	"debug/plan9obj":                    true,
-	"encoding":                          true,
-	"encoding/ascii85":                  true,
-	"encoding/asn1":                     true,
-	"encoding/base32":                   true,
-	"encoding/base64":                   true,
-	"encoding/binary":                   true,
-	"encoding/csv":                      true,
-	"encoding/gob":                      true,
-	"encoding/hex":                      true,
-	"encoding/json":                     true,
-	"encoding/pem":                      true,
-	"encoding/xml":                      true,
-	"errors":                            true,
-	"expvar":                            true,
-	"flag":                              true,
-	"fmt":                               true,
-	"go/ast":                            true,
-	"go/build":                          true,
-	"go/constant":                       true,
-	"go/doc":                            true,
-	"go/format":                         true,
-	"go/importer":                       true,
-	"go/internal/gccgoimporter":         true,
-	"go/internal/gcimporter":            true,
-	"go/parser":                         true,
-	"go/printer":                        true,
-	"go/scanner":                        true,
-	"go/token":                          true,
-	"go/types":                          true,
-	"hash":                              true,
-	"hash/adler32":                      true,
-	"hash/crc32":                        true,
-	"hash/crc64":                        true,
-	"hash/fnv":                          true,
-	"html":                              true,
-	"html/template":                     true,
-	"image":                             true,
-	"image/color":                       true,
-	"image/color/palette":               true,
-	"image/draw":                        true,
-	"image/gif":                         true,
-	"image/internal/imageutil":          true,
-	"image/jpeg":                        true,
-	"image/png":                         true,
-	"index/suffixarray":                 true,
-	"internal/nettrace":                 true,
-	"internal/pprof/profile":            true,
-	"internal/race":                     true,
-	"internal/singleflight":             true,
-	"internal/syscall/unix":             true,
-	"internal/syscall/windows":          true,
-	"internal/syscall/windows/registry": true,
-	"internal/syscall/windows/sysdll":   true,
-	"internal/testenv":                  true,
-	"internal/trace":                    true,
-	"io":                                true,
-	"io/ioutil":                         true,
-	"log":                               true,
-	"log/syslog":                        true,
-	"math":                              true,
-	"math/big":                          true,
-	"math/cmplx":                        true,
-	"math/rand":                         true,
-	"mime":                              true,
-	"mime/multipart":                    true,
-	"mime/quotedprintable":              true,
-	"net":                               true,
-	"net/http":                          true,
-	"net/http/cgi":                      true,
-	"net/http/cookiejar":                true,
-	"net/http/fcgi":                     true,
-	"net/http/httptest":                 true,
-	"net/http/httptrace":                true,
-	"net/http/httputil":                 true,
-	"net/http/internal":                 true,
-	"net/http/pprof":                    true,
-	"net/internal/socktest":             true,
-	"net/mail":                          true,
-	"net/rpc":                           true,
-	"net/rpc/jsonrpc":                   true,
-	"net/smtp":                          true,
-	"net/textproto":                     true,
-	"net/url":                           true,
-	"os":                                true,
-	"os/exec":                           true,
-	"os/signal":                         true,
-	"os/user":                           true,
-	"path":                              true,
-	"path/filepath":                     true,
-	"plugin":                            true,
-	"reflect":                           true,
-	"regexp":                            true,
-	"regexp/syntax":                     true,
-	"runtime":                           true,
-	"runtime/cgo":                       true,
-	"runtime/debug":                     true,
-	"runtime/internal/atomic":           true,
-	"runtime/internal/sys":              true,
-	"runtime/pprof":                     true,
-	"runtime/pprof/internal/protopprof": true,
-	"runtime/race":                      true,
-	"runtime/trace":                     true,
-	"sort":                              true,
-	"strconv":                           true,
-	"strings":                           true,
-	"sync":                              true,
-	"sync/atomic":                       true,
-	"syscall":                           true,
-	"testing":                           true,
-	"testing/internal/testdeps":         true,
-	"testing/iotest":                    true,
-	"testing/quick":                     true,
-	"text/scanner":                      true,
-	"text/tabwriter":                    true,
-	"text/template":                     true,
-	"text/template/parse":               true,
-	"time":                              true,
-	"unicode":                           true,
-	"unicode/utf16":                     true,
-	"unicode/utf8":                      true,
-	"unsafe":                            true,
-	"vendor/golang_org/x/crypto/chacha20poly1305":                   true,
-	"vendor/golang_org/x/crypto/chacha20poly1305/internal/chacha20": true,
-	"vendor/golang_org/x/crypto/curve25519":                         true,
-	"vendor/golang_org/x/crypto/poly1305":                           true,
-	"vendor/golang_org/x/net/http2/hpack":                           true,
-	"vendor/golang_org/x/net/idna":                                  true,
-	"vendor/golang_org/x/net/lex/httplex":                           true,
-	"vendor/golang_org/x/text/transform":                            true,
-	"vendor/golang_org/x/text/unicode/norm":                         true,
-	"vendor/golang_org/x/text/width":                                true,
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/constabulary/kang"
+)
+
+// stdlib holds the import paths of the packages in the standard library
+// of the toolchain in use. It is populated by openProject.
+var stdlib map[string]bool
+
+// loadStdlib returns the set of packages in the standard library of the
+// toolchain in $GOROOT. The packages are found by walking $GOROOT/src,
+// the result is cached in .kang/stdlib, keyed by the toolchain version,
+// so the walk happens once per toolchain.
+func loadStdlib(rootdir string) (map[string]bool, error) {
+	version := kang.ToolchainVersion()
+	path := filepath.Join(rootdir, ".kang", "stdlib", fmt.Sprintf("%x", sha1.Sum([]byte(version))))
+
+	pkgs, err := readStdlib(path)
+	if err == nil {
+		return pkgs, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	pkgs, err = walkStdlib(filepath.Join(runtime.GOROOT(), "src"))
+	if err != nil {
+		return nil, err
+	}
+	return pkgs, writeStdlib(path, version, pkgs)
+}
+
+// walkStdlib returns the import paths of the packages below src, the
+// source directory of a $GOROOT. Commands, and the packages which only
+// they import, are not part of the standard library.
+func walkStdlib(src string) (map[string]bool, error) {
+	pkgs := make(map[string]bool)
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			name := fi.Name()
+			if path != src && (name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			if path == filepath.Join(src, "cmd") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		dir, err := filepath.Rel(src, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if dir != "." {
+			pkgs[filepath.ToSlash(dir)] = true
+		}
+		return nil
+	})
+	return pkgs, err
+}
+
+// readStdlib reads the list of standard library packages cached at path.
+func readStdlib(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pkgs := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pkgs[line] = true
+	}
+	return pkgs, sc.Err()
+}
+
+// writeStdlib caches the list of standard library packages of the
+// toolchain version at path.
+func writeStdlib(path, version string, pkgs map[string]bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var paths []string
+	for p := range pkgs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".stdlib")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(tmp, "# standard library packages of %s\n", version)
+	for _, p := range paths {
+		fmt.Fprintln(tmp, p)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
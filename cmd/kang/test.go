@@ -0,0 +1,267 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/constabulary/kang"
+)
+
+var (
+	testVerbose bool
+	testRun     string
+)
+
+var TestCmd = &Command{
+	Name:      "test",
+	UsageLine: "test [build flags] [-v] [-run regexp] [packages]",
+	Short:     "test the packages in the project",
+	Long: `
+Test compiles and runs the tests of the named packages, by default
+every package in the project. Packages are named as for build, see
+'kang help build', but must be part of the project.
+
+For each package with test files, test compiles the package together
+with its _test.go files, compiles the external _test package if
+present, links them with a generated testmain into a test binary and
+runs it in the package's source directory.
+
+Test prints a summary line for each package and exits with a non
+zero status if any package fails to build or fails its tests. A package
+whose test fails to build does not stop the tests of the others from
+being built and run, as if -k were given.
+
+Test binaries are written to a temporary work directory which is
+removed when test exits. The -work flag prints its name and keeps it.
+
+Test accepts the same build flags as build, see 'kang help build'.
+`,
+	AddFlags: func(fs *flag.FlagSet) {
+		addBuildFlags(fs)
+		fs.BoolVar(&testVerbose, "v", false, "print the output of all tests as they run")
+		fs.StringVar(&testRun, "run", "", "run only tests and examples matching `regexp`")
+	},
+	Run: runTest,
+}
+
+func init() {
+	registerCommand(TestCmd)
+}
+
+func runTest(args []string) error {
+	proj := openProject()
+	ctx := newContext(proj)
+
+	bctx := buildContext(ctx)
+	srcs := loadSources(bctx, proj.prefix, proj.rootdir)
+	paths, err := matchPackages(proj, srcs, args)
+	if err != nil {
+		return err
+	}
+	bySrcPath := make(map[string]*build.Package)
+	for _, src := range srcs {
+		bySrcPath[src.ImportPath] = src
+	}
+	var roots []*build.Package
+	for _, p := range paths {
+		src, ok := bySrcPath[p]
+		if !ok {
+			return fmt.Errorf("%s: only packages in the project can be tested", p)
+		}
+		roots = append(roots, src)
+	}
+
+	var testImports []string
+	for _, src := range roots {
+		testImports = append(testImports, stringList(src.TestImports, src.XTestImports)...)
+	}
+	srcs = importedSources(srcs, paths, true)
+	srcs, lock := loadDependencies(bctx, proj.rootdir, proj.kf, testImports, srcs...)
+
+	// standard library packages imported only by tests, or by the
+	// generated testmains, are not reached from the project's packages,
+	// so are loaded here.
+	std := make(map[string]bool)
+	for _, src := range roots {
+		for _, i := range stringList(src.TestImports, src.XTestImports, testmainImports) {
+			if stdlib[i] {
+				std[i] = true
+			}
+		}
+	}
+	for _, i := range sortedSet(std) {
+		srcs = append(srcs, importStdlib(bctx, i, proj.rootdir))
+	}
+
+	pkgs := transform(ctx, srcs...)
+	if err := lock.write(); err != nil {
+		return err
+	}
+	computeStale(pkgs...)
+
+	byPath := make(map[string]*kang.Package)
+	for _, pkg := range pkgs {
+		byPath[pkg.ImportPath] = pkg
+	}
+
+	targets := make(map[*kang.Package]*Action)
+	var testmains []*kang.Package
+	for _, src := range roots {
+		if len(src.TestGoFiles)+len(src.XTestGoFiles) == 0 {
+			fmt.Printf("?   \t%s\t[no test files]\n", src.ImportPath)
+			continue
+		}
+		testmain, err := testPackage(ctx, src, byPath)
+		if err != nil {
+			return err
+		}
+		testmains = append(testmains, testmain)
+	}
+
+	// build all the test binaries, then run them one at a time. A
+	// test which fails to build must not stop the others, so the build
+	// always keeps going; failures are reported per package below.
+	root, err := buildPackages(targets, testmains...)
+	if err != nil {
+		return err
+	}
+	Execute(root, buildJobs, true)
+
+	var failed bool
+	for _, testmain := range testmains {
+		if !targets[testmain].done {
+			fmt.Printf("FAIL\t%s\t[build failed]\n", testmain.ImportPath)
+			failed = true
+			continue
+		}
+		if err := runTestBinary(testmain); err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("one or more tests failed")
+	}
+	return nil
+}
+
+// testPackage returns the testmain package which, when linked, runs the
+// tests of src. pkgs holds the already transformed packages of the
+// project and its dependencies, indexed by import path.
+func testPackage(ctx *kang.Context, src *build.Package, pkgs map[string]*kang.Package) (*kang.Package, error) {
+	under := pkgs[src.ImportPath]
+	imports := func(paths ...[]string) ([]*kang.Package, error) {
+		var v []*kang.Package
+		seen := make(map[string]bool)
+		for _, path := range stringList(paths...) {
+			if p, ok := under.ImportMap[path]; ok {
+				path = p // vendored
+			}
+			if path == "C" || path == src.ImportPath || seen[path] {
+				// the package under test is replaced
+				// by its test scoped version.
+				continue
+			}
+			seen[path] = true
+			pkg, ok := pkgs[path]
+			if !ok {
+				return nil, fmt.Errorf("%s: test import %s is not loaded", src.ImportPath, path)
+			}
+			v = append(v, pkg)
+		}
+		return v, nil
+	}
+
+	fuzz, err := testmainFuzz(kang.ToolchainVersion())
+	if err != nil {
+		return nil, err
+	}
+	t := testFuncs{
+		ImportPath:  src.ImportPath,
+		ImportTest:  len(src.TestGoFiles) > 0,
+		ImportXtest: len(src.XTestGoFiles) > 0,
+		Fuzz:        fuzz,
+	}
+	if err := t.load(src.Dir, src.TestGoFiles, "_test"); err != nil {
+		return nil, err
+	}
+	if err := t.load(src.Dir, src.XTestGoFiles, "_xtest"); err != nil {
+		return nil, err
+	}
+
+	// the package under test, compiled with its internal test files.
+	testpkg := kang.TestPackage(under, src.ImportPath)
+	testpkg.GoFiles = stringList(src.GoFiles, src.TestGoFiles)
+	testpkg.Imports, err = imports(src.Imports, cgoImports(src), src.TestImports)
+	if err != nil {
+		return nil, err
+	}
+	testpkg.Main = false // imported by the testmain
+	deps := []*kang.Package{testpkg}
+
+	if len(src.XTestGoFiles) > 0 {
+		// the external test package, which imports the
+		// test scoped package under test.
+		xtestImports, err := imports(src.XTestImports)
+		if err != nil {
+			return nil, err
+		}
+		xtestpkg := kang.TestPackage(&kang.Package{
+			Context:    ctx,
+			ImportPath: src.ImportPath + "_test",
+			Dir:        src.Dir,
+			GoFiles:    src.XTestGoFiles,
+			Imports:    append(xtestImports, testpkg),
+		}, src.ImportPath)
+		deps = append(deps, xtestpkg)
+	}
+	std, err := imports(testmainImports)
+	if err != nil {
+		return nil, err
+	}
+	deps = append(deps, std...)
+
+	dir := filepath.Join(ctx.Workdir, filepath.FromSlash(src.ImportPath), "_test")
+	if err := writeTestmain(filepath.Join(dir, "_testmain.go"), &t); err != nil {
+		return nil, err
+	}
+	return kang.TestPackage(&kang.Package{
+		Context:    ctx,
+		ImportPath: src.ImportPath,
+		Dir:        dir,
+		GoFiles:    []string{"_testmain.go"},
+		Imports:    deps,
+		Main:       true,
+	}, src.ImportPath), nil
+}
+
+// runTestBinary runs the test binary for testmain in the directory of the
+// package under test and reports the result.
+func runTestBinary(testmain *kang.Package) error {
+	var args []string
+	if testVerbose {
+		args = append(args, "-test.v")
+	}
+	if testRun != "" {
+		args = append(args, "-test.run", testRun)
+	}
+
+	cmd := exec.Command(testmain.Binfile(), args...)
+	cmd.Dir = testmain.Imports[0].Dir // source directory of the package under test
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start)
+	if err != nil {
+		fmt.Printf("FAIL\t%s\t%.3fs\n", testmain.ImportPath, elapsed.Seconds())
+		return err
+	}
+	fmt.Printf("ok  \t%s\t%.3fs\n", testmain.ImportPath, elapsed.Seconds())
+	return nil
+}
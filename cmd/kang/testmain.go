@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// testFunc is a test, benchmark or example function discovered in a
+// package's test files.
+type testFunc struct {
+	Package   string // alias of the package which declares the function
+	Name      string
+	Output    string // expected output of an example
+	Unordered bool   // the output of an example may appear in any order
+}
+
+// testFuncs describes the contents of a generated testmain package.
+type testFuncs struct {
+	Tests      []testFunc
+	Benchmarks []testFunc
+	Examples   []testFunc
+	TestMain   *testFunc
+
+	ImportTest  bool // the package under test has test files
+	NeedTest    bool // the testmain refers to the package under test
+	ImportXtest bool // the package has external test files
+	NeedXtest   bool // the testmain refers to the external test package
+
+	ImportPath string // import path of the package under test
+	Fuzz       bool   // testing.MainStart takes fuzz targets
+}
+
+// testmainImports are the packages imported by every generated testmain.
+var testmainImports = []string{"os", "testing", "testing/internal/testdeps"}
+
+// testmainFuzz reports whether the testing.MainStart of the toolchain
+// version takes a list of fuzz targets, as it does from Go 1.18. The
+// generated testmain uses testing/internal/testdeps, so requires Go 1.8
+// or later; development toolchains are assumed to be current.
+func testmainFuzz(version string) (bool, error) {
+	if strings.HasPrefix(version, "devel") {
+		return true, nil
+	}
+	var minor int
+	if _, err := fmt.Sscanf(version, "go1.%d", &minor); err != nil {
+		return false, fmt.Errorf("cannot determine the Go release of toolchain %q", version)
+	}
+	if minor < 8 {
+		return false, fmt.Errorf("kang test requires Go 1.8 or later, found %s", version)
+	}
+	return minor >= 18, nil
+}
+
+// load parses the test files of a package and records the test, benchmark
+// and example functions they declare. files are relative to dir, pkg is
+// the alias the testmain will use to refer to their package.
+func (t *testFuncs) load(dir string, files []string, pkg string) error {
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, file), nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		for _, d := range f.Decls {
+			n, ok := d.(*ast.FuncDecl)
+			if !ok || n.Recv != nil {
+				continue
+			}
+			name := n.Name.String()
+			switch {
+			case name == "TestMain" && isTestFunc(n, "M"):
+				if t.TestMain != nil {
+					return fmt.Errorf("%s: multiple definitions of TestMain", fset.Position(n.Pos()))
+				}
+				t.TestMain = &testFunc{Package: pkg, Name: name}
+				t.need(pkg)
+			case isTest(name, "Test") && isTestFunc(n, "T"):
+				t.Tests = append(t.Tests, testFunc{Package: pkg, Name: name})
+				t.need(pkg)
+			case isTest(name, "Benchmark") && isTestFunc(n, "B"):
+				t.Benchmarks = append(t.Benchmarks, testFunc{Package: pkg, Name: name})
+				t.need(pkg)
+			}
+		}
+		for _, e := range doc.Examples(f) {
+			if e.Output == "" && !e.EmptyOutput {
+				// examples without output are compiled, but not run.
+				continue
+			}
+			t.Examples = append(t.Examples, testFunc{Package: pkg, Name: "Example" + e.Name, Output: e.Output, Unordered: e.Unordered})
+			t.need(pkg)
+		}
+	}
+	return nil
+}
+
+// need records that the testmain refers to the package aliased as pkg.
+func (t *testFuncs) need(pkg string) {
+	switch pkg {
+	case "_test":
+		t.NeedTest = true
+	case "_xtest":
+		t.NeedXtest = true
+	}
+}
+
+// isTestFunc reports whether fn has the signature of a test function
+// taking a *testing.<arg>.
+func isTestFunc(fn *ast.FuncDecl, arg string) bool {
+	if fn.Type.Results != nil && len(fn.Type.Results.List) > 0 ||
+		fn.Type.Params.List == nil ||
+		len(fn.Type.Params.List) != 1 ||
+		len(fn.Type.Params.List[0].Names) > 1 {
+		return false
+	}
+	ptr, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	// We can't easily check that the type is *testing.M
+	// because we don't know how testing has been imported,
+	// but at least check that it's *M or *something.M.
+	if name, ok := ptr.X.(*ast.Ident); ok && name.Name == arg {
+		return true
+	}
+	if sel, ok := ptr.X.(*ast.SelectorExpr); ok && sel.Sel.Name == arg {
+		return true
+	}
+	return false
+}
+
+// isTest tells whether name looks like a test (or benchmark, according to prefix).
+// It is a Test (say) if there is a character after Test that is not a lower-case letter.
+// We don't want TesticularCancer.
+func isTest(name, prefix string) bool {
+	if len(name) < len(prefix) || name[:len(prefix)] != prefix {
+		return false
+	}
+	if len(name) == len(prefix) { // "Test" is ok
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// writeTestmain writes the source of the testmain package for t to path.
+func writeTestmain(path string, t *testFuncs) error {
+	var buf bytes.Buffer
+	if err := testmainTmpl.Execute(&buf, t); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+var testmainTmpl = template.Must(template.New("main").Parse(`
+package main
+
+import (
+{{if not .TestMain}}	"os"
+{{end}}	"testing"
+	"testing/internal/testdeps"
+
+{{if .ImportTest}}	{{if .NeedTest}}_test{{else}}_{{end}} {{.ImportPath | printf "%q"}}
+{{end}}{{if .ImportXtest}}	{{if .NeedXtest}}_xtest{{else}}_{{end}} {{.ImportPath | printf "%s_test" | printf "%q"}}
+{{end}})
+
+var tests = []testing.InternalTest{
+{{range .Tests}}	{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{end}}}
+
+var benchmarks = []testing.InternalBenchmark{
+{{range .Benchmarks}}	{"{{.Name}}", {{.Package}}.{{.Name}}},
+{{end}}}
+
+var examples = []testing.InternalExample{
+{{range .Examples}}	{Name: "{{.Name}}", F: {{.Package}}.{{.Name}}, Output: {{.Output | printf "%q"}}, Unordered: {{.Unordered}}},
+{{end}}}
+{{if .Fuzz}}
+var fuzzTargets = []testing.InternalFuzzTarget{}
+{{end}}
+func main() {
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, {{if .Fuzz}}fuzzTargets, {{end}}examples)
+{{with .TestMain}}	{{.Package}}.{{.Name}}(m)
+{{else}}	os.Exit(m.Run())
+{{end}}}
+`))
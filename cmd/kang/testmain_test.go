@@ -0,0 +1,123 @@
+package main
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// pkgImporter type checks the package under test from source and
+// imports everything else with def.
+type pkgImporter struct {
+	path string
+	pkg  *types.Package
+	def  types.Importer
+}
+
+func (i *pkgImporter) Import(path string) (*types.Package, error) {
+	if path == i.path {
+		return i.pkg, nil
+	}
+	return i.def.Import(path)
+}
+
+func TestWriteTestmain(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"p.go": "package p\n\nfunc Hello() string { return \"hello\" }\n",
+		"p_test.go": `package p
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHello(t *testing.T) {}
+
+func BenchmarkHello(b *testing.B) {}
+
+func ExampleHello() {
+	fmt.Println(Hello())
+	// Output: hello
+}
+
+func ExampleHello_unordered() {
+	fmt.Println("b")
+	fmt.Println("a")
+	// Unordered output:
+	// a
+	// b
+}
+
+func ExampleHello_noOutput() {
+	Hello()
+}
+`,
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tf := testFuncs{
+		ImportTest: true,
+		ImportPath: "example.com/p",
+		Fuzz:       true,
+	}
+	if err := tf.load(dir, []string{"p_test.go"}, "_test"); err != nil {
+		t.Fatal(err)
+	}
+	want := []testFunc{
+		{Package: "_test", Name: "ExampleHello", Output: "hello\n"},
+		{Package: "_test", Name: "ExampleHello_unordered", Output: "a\nb\n", Unordered: true},
+	}
+	if len(tf.Examples) != len(want) {
+		t.Fatalf("load: got examples %+v, want %+v", tf.Examples, want)
+	}
+	for i := range want {
+		if tf.Examples[i] != want[i] {
+			t.Errorf("load: got example %+v, want %+v", tf.Examples[i], want[i])
+		}
+	}
+
+	path := filepath.Join(dir, "_testmain", "main.go")
+	if err := writeTestmain(path, &tf); err != nil {
+		t.Fatal(err)
+	}
+
+	// type check the package under test, with its test files, then
+	// the testmain against it.
+	fset := token.NewFileSet()
+	def := importer.ForCompiler(fset, "source", nil)
+	check := func(path string, imp types.Importer, filenames ...string) *types.Package {
+		var files []*ast.File
+		for _, name := range filenames {
+			f, err := parser.ParseFile(fset, name, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, f)
+		}
+		conf := types.Config{Importer: imp}
+		pkg, err := conf.Check(path, fset, files, nil)
+		if err != nil {
+			t.Fatalf("type checking %s: %v", path, err)
+		}
+		return pkg
+	}
+	if runtime.Compiler != "gc" {
+		t.Skipf("type checking the testmain requires the gc toolchain's sources")
+	}
+	pkg := check("example.com/p", def, filepath.Join(dir, "p.go"), filepath.Join(dir, "p_test.go"))
+	check("main", &pkgImporter{path: "example.com/p", pkg: pkg, def: def}, path)
+}
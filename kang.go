@@ -1,14 +1,17 @@
 package kang
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
-	"time"
+	"sync"
+	"unicode"
 )
 
 // Context contains all build specific values.
@@ -22,17 +25,137 @@ type Context struct {
 	gcflags      []string // -gcflags
 	ldflags      []string // -ldflags
 	buildtags    []string
+
+	mu     sync.Mutex
+	hashes map[string]string // file hashes, see hashFile
+}
+
+// NewContext returns a Context which builds for the host platform,
+// configured by the options opts. The exported fields of the returned
+// Context may be set before it is used.
+func NewContext(opts ...func(*Context) error) (*Context, error) {
+	c := Context{
+		GOOS:   runtime.GOOS,
+		GOARCH: runtime.GOARCH,
+	}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
+
+// Force causes every package, other than those in the standard library,
+// to be rebuilt whether or not it is stale.
+func Force(c *Context) error {
+	c.force = true
+	return nil
 }
 
-func (c *Context) isCrossCompile() bool { return false }
+// WithRace enables the race detector. Packages are compiled and linked
+// with -race, the race tag is added to the build tags, and the race
+// enabled standard library is used.
+func WithRace(c *Context) error {
+	c.race = true
+	c.gcflags = append(c.gcflags, "-race")
+	c.ldflags = append(c.ldflags, "-race")
+	return Tags("race")(c)
+}
+
+// Gcflags appends flags to the flags passed to the compiler.
+func Gcflags(flags ...string) func(*Context) error {
+	return func(c *Context) error {
+		c.gcflags = append(c.gcflags, flags...)
+		return nil
+	}
+}
 
-func (c *Context) searchPaths() []string {
-	return []string{
-		c.Workdir,
-		c.Pkgdir,
+// Ldflags appends flags to the flags passed to the linker.
+func Ldflags(flags ...string) func(*Context) error {
+	return func(c *Context) error {
+		c.ldflags = append(c.ldflags, flags...)
+		return nil
+	}
+}
+
+// Tags adds tags to the build tags of the Context. The build tags are
+// kept sorted and free of duplicates. A tag may hold only letters,
+// digits and underscores, as the tags form part of the names of the
+// package directory and linked commands.
+func Tags(tags ...string) func(*Context) error {
+	return func(c *Context) error {
+		seen := make(map[string]bool)
+		var buildtags []string
+		for _, tag := range stringList(c.buildtags, tags) {
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if !ValidTag(tag) {
+				return fmt.Errorf("invalid build tag %q", tag)
+			}
+			seen[tag] = true
+			buildtags = append(buildtags, tag)
+		}
+		sort.Strings(buildtags)
+		c.buildtags = buildtags
+		return nil
 	}
 }
 
+// ValidTag reports whether tag is a valid build tag; a non empty string
+// of letters, digits and underscores.
+func ValidTag(tag string) bool {
+	for _, r := range tag {
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return tag != ""
+}
+
+// Race reports whether the Context builds race enabled packages.
+func (c *Context) Race() bool { return c.race }
+
+// BuildTags returns the build tags of the Context.
+func (c *Context) BuildTags() []string { return c.buildtags }
+
+func (c *Context) isCrossCompile() bool {
+	return c.GOOS != runtime.GOOS || c.GOARCH != runtime.GOARCH
+}
+
+// pkgdir returns the directory inside Pkgdir where archives for
+// the target are stored. Archives built with build tags, including
+// race, are stored apart from those built without, so changing the
+// tags does not overwrite them. Tags are separated by a dash, which
+// may not appear in a tag, so each set of tags has its own directory.
+func (c *Context) pkgdir() string {
+	return filepath.Join(c.Pkgdir, strings.Join(stringList([]string{c.GOOS + "_" + c.GOARCH}, c.buildtags), "-"))
+}
+
+// gorootPkgdir returns the directory inside $GOROOT which holds the
+// installed standard library for the target.
+func (c *Context) gorootPkgdir() string {
+	dir := filepath.Join(runtime.GOROOT(), "pkg", c.GOOS+"_"+c.GOARCH)
+	if c.race {
+		dir += "_race"
+	}
+	return dir
+}
+
+// stdlibInstalled reports whether the standard library for the target
+// is installed in $GOROOT. If it is not, as for cross compilation or
+// with Go 1.20 and later, which ship without it, it is built into Pkgdir.
+func (c *Context) stdlibInstalled() bool {
+	_, err := os.Stat(filepath.Join(c.gorootPkgdir(), "runtime.a"))
+	return err == nil
+}
+
+// environ returns the environment for the compiler and linker.
+func (c *Context) environ() []string {
+	return append(os.Environ(), "GOOS="+c.GOOS, "GOARCH="+c.GOARCH)
+}
+
 // ctxString returns a string representation of the unique properties
 // of the context.
 func (c *Context) ctxString() string {
@@ -48,13 +171,28 @@ func (c *Context) ctxString() string {
 type Package struct {
 	*Context
 	ImportPath string
+	Name       string // package name
 	Dir        string
 	GoFiles    []string
+	CgoFiles   []string // .go files which import "C"
+	CFiles     []string // .c files compiled with the C compiler
+	HFiles     []string // .h files included by CFiles and CgoFiles
+	SFiles     []string // .s files assembled with the Go assembler, see sfiles
 	Imports    []*Package
-	standard   bool // is this part of the stdlib
-	testScope  bool // is a test scoped packge
-	Main       bool // this is a command
-	NotStale   bool // this package _and_ all its dependencies are not stale
+	Standard   bool   // is this part of the stdlib
+	testScope  bool   // is a test scoped packge
+	testRoot   string // import path of the package under test, if testScope
+	Main       bool   // this is a command
+	NotStale   bool   // this package _and_ all its dependencies are not stale
+
+	ImportMap map[string]string // maps vendored imports to their actual import path
+	Output    string            // if set, the path of the linked command, see Binfile
+
+	// cgo directives
+	CgoCFLAGS    []string
+	CgoCPPFLAGS  []string
+	CgoLDFLAGS   []string
+	CgoPkgConfig []string
 }
 
 const debug = true
@@ -75,7 +213,7 @@ func (pkg *Package) IsStale() bool {
 		return false
 	}
 
-	if !pkg.standard && pkg.force {
+	if !pkg.Standard && pkg.force {
 		return true
 	}
 
@@ -84,90 +222,95 @@ func (pkg *Package) IsStale() bool {
 		return true
 	}
 
-	// Package is stale if completely unbuilt.
-	var built time.Time
-	if fi, err := os.Stat(pkg.pkgpath()); err == nil {
-		built = fi.ModTime()
+	if pkg.Standard && pkg.stdlibInstalled() {
+		// if this is a standard lib package, and the standard library
+		// for the target is installed in $GOROOT, then assume the
+		// package is up to date. This also works around golang/go#13769.
+		return false
 	}
 
-	if built.IsZero() {
+	// Package is stale if completely unbuilt.
+	if _, err := os.Stat(pkg.pkgpath()); err != nil {
 		debugf("%s is missing", pkg.pkgpath())
 		return true
 	}
 
-	olderThan := func(file string) bool {
-		fi, err := os.Stat(file)
-		return err != nil || fi.ModTime().After(built)
-	}
-
-	newerThan := func(file string) bool {
-		fi, err := os.Stat(file)
-		return err != nil || fi.ModTime().Before(built)
-	}
-
-	if pkg.standard && !pkg.isCrossCompile() {
-		// if this is a standard lib package, and we are not cross compiling
-		// then assume the package is up to date. This also works around
-		// golang/go#13769.
-		return false
-	}
-
-	// Package is stale if a dependency is newer.
-	for _, p := range pkg.Imports {
-		if p.ImportPath == "C" || p.ImportPath == "unsafe" {
-			continue // ignore stale imports of synthetic packages
-		}
-		if olderThan(p.pkgpath()) {
-			debugf("%s is older than %s", pkg.pkgpath(), p.pkgpath())
+	// if the main package is up to date but the binary has been
+	// removed, then consider it stale.
+	if pkg.Main {
+		if _, err := os.Stat(pkg.Binfile()); err != nil {
+			debugf("%s is missing", pkg.Binfile())
 			return true
 		}
 	}
 
-	// if the main package is up to date but _newer_ than the binary (which
-	// could have been removed), then consider it stale.
-	if pkg.Main && newerThan(pkg.Binfile()) {
-		debugf("%s is newer than %s", pkg.pkgpath(), pkg.Binfile())
+	// Package is stale if its sources, the archives of its dependencies,
+	// the toolchain, or the build flags have changed since it was built.
+	want, err := pkg.buildID()
+	if err != nil {
+		debugf("%s: cannot compute build ID: %v", pkg.ImportPath, err)
 		return true
 	}
-
-	for _, src := range pkg.files() {
-		if olderThan(filepath.Join(pkg.Dir, src)) {
-			debugf("%s is older than %s", pkg.pkgpath(), filepath.Join(pkg.Dir, src))
-			return true
-		}
+	got, err := ioutil.ReadFile(pkg.buildIDFile())
+	if err != nil || string(got) != want {
+		debugf("%s build ID has changed", pkg.pkgpath())
+		return true
 	}
 
 	return false
 }
 
+// TestPackage returns a test scoped copy of pkg, part of the test of
+// the package root. Test scoped packages are compiled into a directory
+// in the Workdir private to the test of root, are never installed, and
+// are always considered stale.
+func TestPackage(pkg *Package, root string) *Package {
+	p := *pkg
+	p.testScope = true
+	p.testRoot = root
+	p.NotStale = false
+	return &p
+}
+
 // files returns all source files in scope
 func (p *Package) files() []string {
-	return stringList(p.GoFiles)
+	return stringList(p.GoFiles, p.CgoFiles, p.CFiles, p.HFiles, p.SFiles)
+}
+
+// Objfile returns the path of the archive this Package is compiled to.
+func (pkg *Package) Objfile() string {
+	return pkg.pkgpath()
 }
 
 // pkgpath returns the destination for object cached for this Package.
 func (pkg *Package) pkgpath() string {
 	importpath := filepath.FromSlash(pkg.ImportPath) + ".a"
 	switch {
-	case pkg.isCrossCompile():
-		return filepath.Join(pkg.Pkgdir, importpath)
-	case pkg.standard && pkg.race:
-		// race enabled standard lib
-		return filepath.Join(runtime.GOROOT(), "pkg", pkg.GOOS+"_"+pkg.GOARCH+"_race", importpath)
-	case pkg.standard:
-		// standard lib
-		return filepath.Join(runtime.GOROOT(), "pkg", pkg.GOOS+"_"+pkg.GOARCH, importpath)
+	case pkg.testScope && pkg.Main:
+		// synthesised testmain package
+		return filepath.Join(pkg.testdir(), "main.a")
+	case pkg.testScope:
+		// test scoped packages shadow their installed versions,
+		// but only within the test of their root package.
+		return filepath.Join(pkg.testdir(), importpath)
+	case pkg.Standard && pkg.stdlibInstalled():
+		// standard lib, possibly race enabled
+		return filepath.Join(pkg.gorootPkgdir(), importpath)
 	default:
-		return filepath.Join(pkg.Pkgdir, importpath)
+		return filepath.Join(pkg.pkgdir(), importpath)
 	}
 }
 
 // Binfile returns the destination of the compiled target of this command.
+// If Output is set it is returned unchanged.
 func (pkg *Package) Binfile() string {
+	if pkg.Output != "" {
+		return pkg.Output
+	}
 	// TODO(dfc) should have a check for package main, or should be merged in to objfile.
 	target := filepath.Join(pkg.Bindir, pkg.binname())
 	if pkg.testScope {
-		target = filepath.Join(pkg.Workdir, filepath.FromSlash(pkg.ImportPath), "_test", pkg.binname())
+		target = filepath.Join(pkg.testdir(), pkg.binname())
 	}
 
 	// if this is a cross compile or GOOS/GOARCH are both defined or there are build tags, add ctxString.
@@ -194,12 +337,104 @@ func (pkg *Package) binname() string {
 	}
 }
 
+// complete reports whether the package is written entirely in Go;
+// the compiler may then reject body-less function declarations.
 func (p *Package) complete() bool {
-	return true // no cgo or runtime code
+	if p.Standard && incompleteStdlib[p.ImportPath] {
+		return false
+	}
+	return len(p.CgoFiles)+len(p.CFiles)+len(p.SFiles) == 0
+}
+
+// incompleteStdlib are the standard library packages which, although
+// written entirely in Go, declare functions without bodies which are
+// provided by the runtime with go:linkname. The list is that of
+// cmd/go.
+var incompleteStdlib = map[string]bool{
+	"bytes":           true, // bytes.indexBytePortable
+	"internal/poll":   true, // internal/poll.runtime_Semacquire
+	"net":             true, // net.runtime_...
+	"os":              true, // os.runtime_args
+	"runtime/metrics": true, // runtime/metrics.runtime_readMetrics
+	"runtime/pprof":   true, // runtime/pprof.runtime_cyclesPerSecond
+	"runtime/trace":   true, // runtime/trace.userTaskCreate
+	"sync":            true, // sync.runtime_Semacquire
+	"syscall":         true, // syscall.runtime_envs
+	"time":            true, // time.now
+}
+
+// objdir returns the directory in which intermediate files for
+// pkg are written.
+func (pkg *Package) objdir() string {
+	dir := filepath.Join(pkg.Workdir, filepath.FromSlash(pkg.ImportPath))
+	if pkg.testScope {
+		dir = filepath.Join(dir, "_test")
+	}
+	return dir
+}
+
+// testdir returns the directory in the Workdir holding the archives
+// of the test of the package testRoot.
+func (pkg *Package) testdir() string {
+	return filepath.Join(pkg.Workdir, filepath.FromSlash(pkg.testRoot), "_test")
+}
+
+// searchPaths returns the directories searched for the archives of the
+// imports of pkg. The test directory is searched only by the test scoped
+// packages of the test it belongs to, so no other package can be
+// compiled or linked against a test build.
+func (pkg *Package) searchPaths() []string {
+	if pkg.testScope {
+		return []string{pkg.testdir(), pkg.pkgdir()}
+	}
+	return []string{pkg.pkgdir()}
+}
+
+// importMapArgs returns the compiler flags which map the vendored imports
+// of pkg to their actual import paths. Toolchains without -importmap take
+// the mapping from an import configuration file, which must then also
+// list the archive of every import, as it replaces the search paths.
+func (pkg *Package) importMapArgs() ([]string, error) {
+	var args []string
+	if toolFlag("compile", "importmap") {
+		for _, from := range sortedKeys(pkg.ImportMap) {
+			args = append(args, "-importmap", from+"="+pkg.ImportMap[from])
+		}
+		return args, nil
+	}
+
+	var buf bytes.Buffer
+	for _, from := range sortedKeys(pkg.ImportMap) {
+		fmt.Fprintf(&buf, "importmap %s=%s\n", from, pkg.ImportMap[from])
+	}
+	for _, i := range pkg.Imports {
+		switch i.ImportPath {
+		case "C", "unsafe":
+			continue
+		}
+		fmt.Fprintf(&buf, "packagefile %s=%s\n", i.ImportPath, i.pkgpath())
+	}
+	if err := mkdir(pkg.objdir()); err != nil {
+		return nil, err
+	}
+	importcfg := filepath.Join(pkg.objdir(), "importcfg")
+	if err := ioutil.WriteFile(importcfg, buf.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	return []string{"-importcfg", importcfg}, nil
 }
 
 func (p *Package) name() string { return filepath.FromSlash(p.ImportPath) }
 
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func stringList(args ...[]string) []string {
 	var l []string
 	for _, arg := range args {
@@ -208,13 +443,28 @@ func stringList(args ...[]string) []string {
 	return l
 }
 
+// compilePath returns the import path pkg is compiled and assembled as,
+// which prefixes the symbols it defines.
+func (pkg *Package) compilePath() string {
+	if pkg.Main {
+		// the linker looks for main.main; this also keeps the
+		// testmain from sharing a symbol prefix with the package
+		// under test.
+		return "main"
+	}
+	return pkg.ImportPath
+}
+
 func (pkg *Package) Compile() error {
-	args := append(pkg.gcflags, "-p", pkg.ImportPath, "-pack")
+	args := stringList(pkg.gcflags, []string{"-p", pkg.compilePath(), "-pack"})
 	args = append(args, "-o", pkg.pkgpath())
 	for _, d := range pkg.searchPaths() {
 		args = append(args, "-I", d)
 	}
-	if pkg.standard && pkg.ImportPath == "runtime" {
+	if pkg.Standard && toolFlag("compile", "std") {
+		args = append(args, "-std")
+	}
+	if pkg.Standard && pkg.ImportPath == "runtime" {
 		// runtime compiles with a special gc flag to emit
 		// additional reflect type data.
 		args = append(args, "-+")
@@ -225,49 +475,154 @@ func (pkg *Package) Compile() error {
 		args = append(args, "-complete")
 	}
 
-	args = append(args, pkg.GoFiles...)
+	sfiles, _ := pkg.sfiles()
+	if len(sfiles) > 0 {
+		// write the header of Go definitions for
+		// the assembly sources to include.
+		if err := mkdir(pkg.objdir()); err != nil {
+			return err
+		}
+		args = append(args, "-asmhdr", filepath.Join(pkg.objdir(), "go_asm.h"))
+		symabis, err := pkg.symabis(sfiles)
+		if err != nil {
+			return err
+		}
+		if symabis != "" {
+			args = append(args, "-symabis", symabis)
+		}
+	}
+
+	if len(pkg.ImportMap) > 0 {
+		importmap, err := pkg.importMapArgs()
+		if err != nil {
+			return err
+		}
+		args = append(args, importmap...)
+	}
+
+	gofiles := pkg.GoFiles
+	var ofiles []string
+	if len(pkg.CgoFiles) > 0 {
+		var err error
+		var cgofiles []string
+		cgofiles, ofiles, err = pkg.cgo()
+		if err != nil {
+			return err
+		}
+		gofiles = stringList(gofiles, cgofiles)
+	}
+
+	args = append(args, gofiles...)
 	if err := mkdir(filepath.Dir(pkg.pkgpath())); err != nil {
 		return err
 	}
-	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "pkg", "tool", runtime.GOOS+"_"+runtime.GOARCH, "compile"), args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := exec.Command(tool("compile"), args...)
+	cmd.Env = pkg.environ()
 	cmd.Dir = pkg.Dir
-	fmt.Fprintf(os.Stderr, "+ %s\n", strings.Join(cmd.Args, " "))
-	return cmd.Run()
+	pkg.forgetHash(pkg.pkgpath())
+	if err := run(cmd); err != nil {
+		return err
+	}
+	if len(sfiles) > 0 {
+		sofiles, err := pkg.asm(sfiles)
+		if err != nil {
+			return err
+		}
+		ofiles = append(ofiles, sofiles...)
+	}
+	if len(ofiles) > 0 {
+		if err := pkg.pack(ofiles...); err != nil {
+			return err
+		}
+	}
+	if pkg.Main || pkg.testScope {
+		// commands record their build ID once linked;
+		// tests are never installed.
+		return nil
+	}
+	return pkg.writeBuildID()
 }
 
 func (pkg *Package) Link() error {
 	// to ensure we don't write a partial binary, link the binary to a temporary file in
 	// in the target directory, then rename.
+	if err := mkdir(filepath.Dir(pkg.Binfile())); err != nil {
+		return err
+	}
 	tmp, err := ioutil.TempFile(filepath.Dir(pkg.Binfile()), ".kang-link")
 	if err != nil {
 		return err
 	}
 	tmp.Close()
 
-	args := append(pkg.ldflags, "-o", tmp.Name())
+	args := stringList(pkg.ldflags, []string{"-o", tmp.Name()})
 	for _, d := range pkg.searchPaths() {
 		args = append(args, "-L", d)
 	}
 	args = append(args, "-buildmode", "exe")
+	if pkg.externalLink() {
+		args = append(args, "-linkmode", "external", "-extld", cc())
+	}
 	args = append(args, pkg.pkgpath())
 
-	cmd := exec.Command(filepath.Join(runtime.GOROOT(), "pkg", "tool", runtime.GOOS+"_"+runtime.GOARCH, "link"), args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := exec.Command(tool("link"), args...)
+	cmd.Env = pkg.environ()
 	cmd.Dir = pkg.Workdir
-	fmt.Fprintf(os.Stderr, "+ %s\n", strings.Join(cmd.Args, " "))
-	if err := cmd.Run(); err != nil {
+	if err := run(cmd); err != nil {
 		os.Remove(tmp.Name()) // remove partial file
 		return err
 	}
-	if err := mkdir(filepath.Dir(pkg.Binfile())); err != nil {
-		os.Remove(tmp.Name()) // remove partial file
+	if err := rename(tmp.Name(), pkg.Binfile()); err != nil {
 		return err
 	}
+	if pkg.testScope {
+		return nil
+	}
+	return pkg.writeBuildID()
+}
+
+// tool returns the path to the named tool of the host toolchain.
+func tool(name string) string {
+	return filepath.Join(runtime.GOROOT(), "pkg", "tool", runtime.GOOS+"_"+runtime.GOARCH, name)
+}
 
-	return rename(tmp.Name(), pkg.Binfile())
+var toolFlags = struct {
+	sync.Mutex
+	m map[string]map[string]bool // flags accepted by each tool
+}{m: make(map[string]map[string]bool)}
+
+// toolFlag reports whether the named tool of the host toolchain accepts
+// flag, according to the usage message it prints. Flags come and go
+// between releases of the toolchain.
+func toolFlag(name, flag string) bool {
+	toolFlags.Lock()
+	defer toolFlags.Unlock()
+	flags, ok := toolFlags.m[name]
+	if !ok {
+		flags = make(map[string]bool)
+		out, _ := exec.Command(tool(name), "-help").CombinedOutput()
+		for _, line := range strings.Split(string(out), "\n") {
+			if f := strings.Fields(line); len(f) > 0 && strings.HasPrefix(f[0], "-") {
+				flags[f[0][1:]] = true
+			}
+		}
+		toolFlags.m[name] = flags
+	}
+	return flags[flag]
+}
+
+// run runs cmd, echoing it to os.Stderr first. The output of cmd is
+// buffered and written in one piece once cmd exits, so the output of
+// commands running concurrently is not interleaved. If cmd fails, its
+// output is returned as part of the error.
+func run(cmd *exec.Cmd) error {
+	fmt.Fprintf(os.Stderr, "+ %s\n", strings.Join(cmd.Args, " "))
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v\n%s", filepath.Base(cmd.Path), err, out)
+	}
+	os.Stderr.Write(out)
+	return nil
 }
 
 func mkdir(path string) error {
@@ -0,0 +1,67 @@
+package kang
+
+import "testing"
+
+func TestCheckCompilerFlags(t *testing.T) {
+	tests := []struct {
+		flags []string
+		err   string // blank if the flags are accepted
+	}{
+		{flags: []string{"-DFOO", "-I/usr/include/foo", "-O2", "-Wall", "-fno-common"}},
+		{flags: []string{"-I", "/usr/include/foo", "-D", "FOO=1", "-x", "c"}},
+		{flags: []string{"-fplugin=./evil.so"}, err: "invalid flag in p.go: -fplugin=./evil.so"},
+		{flags: []string{"-Wa,-foo"}, err: "invalid flag in p.go: -Wa,-foo"},
+		{flags: []string{"@flags.txt"}, err: "invalid flag in p.go: @flags.txt"},
+		{flags: []string{"-I@flags.txt"}, err: "invalid flag in p.go: -I@flags.txt"},
+		{flags: []string{"-I-fplugin=./evil.so"}, err: "invalid flag in p.go: -I-fplugin=./evil.so"},
+
+		// flags which take the next argument must not be followed by
+		// a flag, or a response file.
+		{flags: []string{"-I", "-fplugin=./evil.so"}, err: "invalid flag in p.go: -I -fplugin=./evil.so"},
+		{flags: []string{"-x", "-"}, err: "invalid flag in p.go: -x -"},
+		{flags: []string{"-include", "@flags.txt"}, err: "invalid flag in p.go: -include @flags.txt"},
+		{flags: []string{"-D", ""}, err: "invalid flag in p.go: -D "},
+		{flags: []string{"-I"}, err: "invalid flag in p.go: -I without argument"},
+	}
+	for _, tt := range tests {
+		err := checkCompilerFlags("p.go", tt.flags)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("checkCompilerFlags(%q): %v", tt.flags, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("checkCompilerFlags(%q): got error %v, want %q", tt.flags, err, tt.err)
+		}
+	}
+}
+
+func TestCheckLinkerFlags(t *testing.T) {
+	tests := []struct {
+		flags []string
+		err   string // blank if the flags are accepted
+	}{
+		{flags: []string{"-lfoo", "-L/usr/lib/foo", "-pthread", "libfoo.a", "./foo.o"}},
+		{flags: []string{"-l", "foo", "-framework", "CoreFoundation", "-Wl,-rpath", "/usr/lib/foo"}},
+		{flags: []string{"-Wl,--wrapper,./evil"}, err: "invalid flag in p.go: -Wl,--wrapper,./evil"},
+		{flags: []string{"-Wl,--wrapper=./evil"}, err: "invalid flag in p.go: -Wl,--wrapper=./evil"},
+		{flags: []string{"-fplugin=./evil.so"}, err: "invalid flag in p.go: -fplugin=./evil.so"},
+		{flags: []string{"@flags.txt"}, err: "invalid flag in p.go: @flags.txt"},
+		{flags: []string{"-Wl,@flags.txt"}, err: "invalid flag in p.go: -Wl,@flags.txt"},
+		{flags: []string{"-foo.o"}, err: "invalid flag in p.go: -foo.o"},
+
+		// flags which take the next argument must not be followed by
+		// a flag, or a response file.
+		{flags: []string{"-framework", "-Wl,--wrapper,./evil"}, err: "invalid flag in p.go: -framework -Wl,--wrapper,./evil"},
+		{flags: []string{"-L", "-fplugin=./evil.so"}, err: "invalid flag in p.go: -L -fplugin=./evil.so"},
+		{flags: []string{"-Wl,-rpath", "@flags.txt"}, err: "invalid flag in p.go: -Wl,-rpath @flags.txt"},
+		{flags: []string{"-l"}, err: "invalid flag in p.go: -l without argument"},
+	}
+	for _, tt := range tests {
+		err := checkLinkerFlags("p.go", tt.flags)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("checkLinkerFlags(%q): %v", tt.flags, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("checkLinkerFlags(%q): got error %v, want %q", tt.flags, err, tt.err)
+		}
+	}
+}